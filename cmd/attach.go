@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/akm/godocker/container"
+)
+
+// Attach 连接到运行中容器的标准输入输出
+func Attach(args []string) {
+	attachCmd := flag.NewFlagSet("attach", flag.ExitOnError)
+	detachKeys := attachCmd.String("detach-keys", container.DefaultDetachKeys, "从容器分离的按键序列")
+
+	if err := attachCmd.Parse(args); err != nil {
+		fmt.Println("解析参数错误:", err)
+		os.Exit(1)
+	}
+
+	if attachCmd.NArg() != 1 {
+		fmt.Println("请指定要连接的容器ID，例如: godocker attach [container-id]")
+		os.Exit(1)
+	}
+
+	attachAndExit(attachCmd.Arg(0), *detachKeys)
+}
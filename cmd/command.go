@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"flag"
 	"fmt"
+	"os"
 
 	"github.com/akm/godocker/container"
 	"github.com/akm/godocker/image"
@@ -32,11 +34,22 @@ func Ps() {
 			c.ID[:12],
 			c.Image,
 			cmd,
-			c.Status,
+			formatStatus(c),
 			c.CreateTime.Format("2006-01-02 15:04:05"))
 	}
 }
 
+// 格式化容器状态，已退出的容器附带退出码
+func formatStatus(c *container.ContainerInfo) string {
+	if c.Status != container.StatusExited {
+		return c.Status.String()
+	}
+	if c.OOMKilled {
+		return fmt.Sprintf("%s (%d, OOM)", c.Status, c.ExitCode)
+	}
+	return fmt.Sprintf("%s (%d)", c.Status, c.ExitCode)
+}
+
 // Images 列出本地镜像
 func Images() {
 	images, err := image.ListImages()
@@ -82,13 +95,28 @@ func Stop(containerID string) {
 }
 
 // Remove 删除容器
-func Remove(containerID string) {
-	if err := container.RemoveContainer(containerID); err != nil {
-		fmt.Printf("删除容器失败: %v\n", err)
-		return
+func Remove(args []string) {
+	rmCmd := flag.NewFlagSet("rm", flag.ExitOnError)
+	force := rmCmd.Bool("f", false, "强制删除运行中的容器")
+
+	if err := rmCmd.Parse(args); err != nil {
+		fmt.Println("解析参数错误:", err)
+		os.Exit(1)
 	}
 
-	fmt.Printf("容器 %s 已删除\n", containerID)
+	if rmCmd.NArg() < 1 {
+		fmt.Println("请指定要删除的容器ID，例如: godocker rm [-f] [container-id]")
+		os.Exit(1)
+	}
+
+	for _, containerID := range rmCmd.Args() {
+		if err := container.RemoveContainer(containerID, *force); err != nil {
+			fmt.Printf("删除容器失败: %v\n", err)
+			continue
+		}
+
+		fmt.Printf("容器 %s 已删除\n", containerID)
+	}
 }
 
 // 格式化文件大小
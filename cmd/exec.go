@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/akm/godocker/container"
+)
+
+// Exec 在运行中的容器内执行命令
+func Exec(args []string) {
+	execCmd := flag.NewFlagSet("exec", flag.ExitOnError)
+
+	var env stringSliceFlag
+	tty := execCmd.Bool("it", false, "启用交互式终端")
+	interactive := execCmd.Bool("i", false, "保持标准输入打开")
+	user := execCmd.String("u", "", "执行命令的用户 (如 'nobody' 或 '1000:1000')")
+	workDir := execCmd.String("w", "", "容器内的工作目录")
+	execCmd.Var(&env, "e", "设置环境变量 (如 'KEY=VALUE'，可重复指定)")
+
+	if err := execCmd.Parse(args); err != nil {
+		fmt.Println("解析参数错误:", err)
+		os.Exit(1)
+	}
+
+	if execCmd.NArg() < 2 {
+		fmt.Println("请指定容器和要执行的命令，例如: godocker exec -it [container-id] /bin/sh")
+		os.Exit(1)
+	}
+
+	execConfig := &container.ExecConfig{
+		Command:     execCmd.Args()[1:],
+		Env:         env,
+		User:        *user,
+		WorkDir:     *workDir,
+		Interactive: *interactive || *tty,
+		Tty:         *tty,
+	}
+
+	exitCode, err := container.ExecContainer(execCmd.Arg(0), execConfig)
+	if err != nil {
+		fmt.Printf("执行命令失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	os.Exit(exitCode)
+}
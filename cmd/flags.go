@@ -0,0 +1,15 @@
+package cmd
+
+import "strings"
+
+// stringSliceFlag 可以重复指定的字符串参数，如 -e A=1 -e B=2
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/template"
+
+	"github.com/akm/godocker/container"
+	"github.com/akm/godocker/image"
+	"github.com/akm/godocker/network"
+	"github.com/akm/godocker/volume"
+)
+
+// inspectType inspect命令支持的对象类型
+type inspectType struct {
+	name     string
+	find     func(ref string) (interface{}, error)
+	notFound error // 找不到对象时返回的错误，用于继续尝试下一种类型
+}
+
+// 未指定 --type 时按顺序尝试的对象类型
+var inspectTypes = []inspectType{
+	{
+		name: "container",
+		find: func(ref string) (interface{}, error) {
+			return container.InspectContainer(ref)
+		},
+		notFound: container.ErrContainerNotFound,
+	},
+	{
+		name: "image",
+		find: func(ref string) (interface{}, error) {
+			return image.FindImage(ref)
+		},
+		notFound: image.ErrImageNotFound,
+	},
+	{
+		name: "network",
+		find: func(ref string) (interface{}, error) {
+			return network.InspectNetwork(ref)
+		},
+		notFound: network.ErrNetworkNotFound,
+	},
+	{
+		name: "volume",
+		find: func(ref string) (interface{}, error) {
+			return volume.Get(ref)
+		},
+		notFound: volume.ErrVolumeNotFound,
+	},
+	{
+		name: "pod",
+		find: func(ref string) (interface{}, error) {
+			return container.InspectPod(ref)
+		},
+		notFound: container.ErrPodNotFound,
+	},
+}
+
+// Inspect 以JSON格式输出容器、镜像、网络、数据卷或pod的详细信息
+func Inspect(args []string) {
+	inspectCmd := flag.NewFlagSet("inspect", flag.ExitOnError)
+	format := inspectCmd.String("format", "", "使用Go模板格式化输出 (如 '{{.State.Pid}}')")
+	inspectCmd.StringVar(format, "f", "", "--format 的简写")
+	objectType := inspectCmd.String("type", "", "只查找指定类型的对象 (container, image, network, volume, pod)")
+
+	if err := inspectCmd.Parse(args); err != nil {
+		fmt.Println("解析参数错误:", err)
+		os.Exit(1)
+	}
+
+	if inspectCmd.NArg() < 1 {
+		fmt.Println("请指定要查看的对象，例如: godocker inspect [container-id]")
+		os.Exit(1)
+	}
+
+	types := inspectTypes
+	if *objectType != "" {
+		types = nil
+		for _, t := range inspectTypes {
+			if t.name == *objectType {
+				types = []inspectType{t}
+			}
+		}
+		if types == nil {
+			fmt.Printf("不支持的对象类型: %s\n", *objectType)
+			os.Exit(1)
+		}
+	}
+
+	var tmpl *template.Template
+	if *format != "" {
+		var err error
+		tmpl, err = template.New("format").Funcs(template.FuncMap{"json": formatJSON}).Parse(*format)
+		if err != nil {
+			fmt.Printf("解析格式模板失败: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// 找不到的对象不影响其他对象的输出，最后以非零状态退出
+	failed := false
+	results := []interface{}{}
+	for _, ref := range inspectCmd.Args() {
+		object, err := findObject(ref, types)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			failed = true
+			continue
+		}
+
+		if tmpl == nil {
+			results = append(results, object)
+			continue
+		}
+		if err := tmpl.Execute(os.Stdout, object); err != nil {
+			fmt.Fprintf(os.Stderr, "格式化输出失败: %v\n", err)
+			failed = true
+			continue
+		}
+		fmt.Println()
+	}
+
+	if tmpl == nil {
+		data, err := json.MarshalIndent(results, "", "    ")
+		if err != nil {
+			fmt.Printf("序列化失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// findObject 依次按各类型查找对象
+func findObject(ref string, types []inspectType) (interface{}, error) {
+	for _, t := range types {
+		object, err := t.find(ref)
+		if err == nil {
+			return object, nil
+		}
+		if !errors.Is(err, t.notFound) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("找不到对象: %s", ref)
+}
+
+// formatJSON 模板函数，以JSON格式输出值，如 '{{json .Config}}'
+func formatJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
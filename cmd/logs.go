@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/akm/godocker/container"
+)
+
+// Logs 输出容器日志
+func Logs(args []string) {
+	logsCmd := flag.NewFlagSet("logs", flag.ExitOnError)
+
+	follow := logsCmd.Bool("f", false, "持续输出新日志")
+	tail := logsCmd.Int("tail", -1, "只输出最后N行")
+	since := logsCmd.String("since", "", "只输出该时间之后的日志 (如 '2024-01-02T15:04:05Z' 或 '10m')")
+	timestamps := logsCmd.Bool("t", false, "显示时间戳")
+
+	if err := logsCmd.Parse(args); err != nil {
+		fmt.Println("解析参数错误:", err)
+		os.Exit(1)
+	}
+
+	if logsCmd.NArg() != 1 {
+		fmt.Println("请指定容器ID，例如: godocker logs -f [container-id]")
+		os.Exit(1)
+	}
+
+	options := container.LogOptions{
+		Follow:     *follow,
+		Tail:       *tail,
+		Timestamps: *timestamps,
+	}
+
+	if *since != "" {
+		sinceTime, err := parseSince(*since)
+		if err != nil {
+			fmt.Printf("无效的时间: %v\n", err)
+			os.Exit(1)
+		}
+		options.Since = sinceTime
+	}
+
+	if err := container.ReadContainerLogs(logsCmd.Arg(0), options, os.Stdout, os.Stderr); err != nil {
+		fmt.Printf("读取容器日志失败: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// 解析 --since 参数，支持RFC3339时间、Unix时间戳和相对时长
+func parseSince(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04:05", value, time.Local); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("无法解析 %s", value)
+}
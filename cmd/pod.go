@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/akm/godocker/container"
+	"github.com/akm/godocker/network"
+)
+
+// Pod 管理pod
+func Pod(args []string) {
+	if len(args) < 1 {
+		printPodUsage()
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "create":
+		podCreate(args[1:])
+	case "ls":
+		podList()
+	case "inspect":
+		Inspect(append([]string{"-type", "pod"}, args[1:]...))
+	case "start":
+		podStart(args[1:])
+	case "stop":
+		podStop(args[1:])
+	case "rm":
+		podRemove(args[1:])
+	default:
+		fmt.Printf("未知的pod命令: %s\n", args[0])
+		printPodUsage()
+		os.Exit(1)
+	}
+}
+
+func printPodUsage() {
+	fmt.Println("用法: godocker pod [命令]")
+	fmt.Println("\n可用命令:")
+	fmt.Println("  create   创建pod (--name 指定名称，-net 网络模式，-p 主机端口:容器端口 发布端口)")
+	fmt.Println("  ls       列出pod")
+	fmt.Println("  inspect  查看pod的详细信息")
+	fmt.Println("  start    启动pod及其所有容器")
+	fmt.Println("  stop     停止pod及其所有容器")
+	fmt.Println("  rm       删除pod及其所有容器 (-f 强制删除运行中的pod)")
+	fmt.Println("\n使用 godocker run --pod [pod名称] 在pod中运行容器")
+}
+
+// podCreate 创建pod
+func podCreate(args []string) {
+	createCmd := flag.NewFlagSet("pod create", flag.ExitOnError)
+	name := createCmd.String("name", "", "指定pod名称，同时作为成员容器的主机名")
+	netMode := createCmd.String("net", network.BridgeMode, "网络模式 (bridge, host, none)")
+	var publish stringSliceFlag
+	createCmd.Var(&publish, "p", "发布端口 (如 '8080:80' 或 '5353:53/udp'，可重复指定)")
+
+	if err := createCmd.Parse(args); err != nil {
+		fmt.Println("解析参数错误:", err)
+		os.Exit(1)
+	}
+
+	podConfig := &container.PodConfig{
+		Name:    *name,
+		Network: *netMode,
+	}
+	for _, spec := range publish {
+		port, err := network.ParsePortMapping(spec)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		podConfig.Ports = append(podConfig.Ports, port)
+	}
+
+	podId, err := container.CreatePod(podConfig)
+	if err != nil {
+		fmt.Printf("创建pod失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(podId)
+}
+
+// podList 列出pod
+func podList() {
+	pods, err := container.ListPods()
+	if err != nil {
+		fmt.Printf("获取pod列表失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-12s %-20s %-10s %-8s %-20s\n", "pod ID", "名称", "状态", "容器数", "创建时间")
+	fmt.Println("----------------------------------------------------------------------")
+	for _, p := range pods {
+		fmt.Printf("%-12s %-20s %-10s %-8d %-20s\n",
+			p.ID[:12],
+			p.Name,
+			p.Status,
+			len(p.Containers),
+			p.CreateTime.Format("2006-01-02 15:04:05"))
+	}
+}
+
+// podStart 启动pod
+func podStart(args []string) {
+	if len(args) < 1 {
+		fmt.Println("请指定要启动的pod，例如: godocker pod start [pod]")
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, ref := range args {
+		if err := container.StartPod(ref); err != nil {
+			fmt.Printf("启动pod失败: %v\n", err)
+			failed = true
+			continue
+		}
+		fmt.Printf("pod %s 已启动\n", ref)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// podStop 停止pod
+func podStop(args []string) {
+	if len(args) < 1 {
+		fmt.Println("请指定要停止的pod，例如: godocker pod stop [pod]")
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, ref := range args {
+		if err := container.StopPod(ref); err != nil {
+			fmt.Printf("停止pod失败: %v\n", err)
+			failed = true
+			continue
+		}
+		fmt.Printf("pod %s 已停止\n", ref)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// podRemove 删除pod
+func podRemove(args []string) {
+	rmCmd := flag.NewFlagSet("pod rm", flag.ExitOnError)
+	force := rmCmd.Bool("f", false, "先停止运行中的pod及其容器再删除")
+
+	if err := rmCmd.Parse(args); err != nil {
+		fmt.Println("解析参数错误:", err)
+		os.Exit(1)
+	}
+
+	if rmCmd.NArg() < 1 {
+		fmt.Println("请指定要删除的pod，例如: godocker pod rm [-f] [pod]")
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, ref := range rmCmd.Args() {
+		if err := container.RemovePod(ref, *force); err != nil {
+			fmt.Printf("删除pod失败: %v\n", err)
+			failed = true
+			continue
+		}
+		fmt.Printf("pod %s 已删除\n", ref)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
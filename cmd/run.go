@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -9,46 +10,153 @@ import (
 
 	"github.com/akm/godocker/container"
 	"github.com/akm/godocker/resources"
+	"github.com/akm/godocker/volume"
 )
 
-// Run 实现容器的运行命令
-func Run(args []string) {
-	// 解析run命令的参数
-	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
-
-	// 定义run命令参数
-	tty := runCmd.Bool("it", false, "启用交互式终端")
-	memory := runCmd.String("m", "", "内存限制 (如 '100m')")
-	cpuShare := runCmd.String("cpuset", "", "CPU核心使用限制 (如 '0,1')")
-	volume := runCmd.String("v", "", "数据卷映射 (如 '/host:/container')")
-	name := runCmd.String("name", "", "指定容器名称")
-	network := runCmd.String("net", "bridge", "指定网络模式")
-	detach := runCmd.Bool("d", false, "后台运行容器")
+// runOptions run和create命令共用的容器参数
+type runOptions struct {
+	flags    *flag.FlagSet
+	tty      *bool
+	stdin    *bool
+	memory   *string
+	cpuShare *string
+	volumes  stringSliceFlag
+	tmpfs    stringSliceFlag
+	devices  stringSliceFlag
+	security stringSliceFlag
+	shmSize  *string
+	readOnly *bool
+	userns   *string
+	uidMap   stringSliceFlag
+	gidMap   stringSliceFlag
+	name     *string
+	network  *string
+	pid      *string
+	ipc      *string
+	uts      *string
+	pod      *string
+	env      stringSliceFlag
+	workDir  *string
+	user     *string
+	hostname *string
+}
 
-	if err := runCmd.Parse(args); err != nil {
-		fmt.Println("解析参数错误:", err)
-		os.Exit(1)
+// addRunFlags 注册run和create命令共用的参数
+func addRunFlags(fs *flag.FlagSet) *runOptions {
+	opts := &runOptions{
+		flags:    fs,
+		tty:      fs.Bool("it", false, "启用交互式终端"),
+		stdin:    fs.Bool("i", false, "保持标准输入打开"),
+		memory:   fs.String("m", "", "内存限制 (如 '100m')"),
+		cpuShare: fs.String("cpuset", "", "CPU核心使用限制 (如 '0,1')"),
+		name:     fs.String("name", "", "指定容器名称"),
+		network:  fs.String("net", "bridge", "网络模式 (bridge, host, none 或 container:<名称|ID>)"),
+		pid:      fs.String("pid", "", "pid namespace，'host' 与主机共享，'container:<名称|ID>' 加入该容器的"),
+		ipc:      fs.String("ipc", "", "ipc namespace，'host' 与主机共享，'container:<名称|ID>' 加入该容器的"),
+		uts:      fs.String("uts", "", "uts namespace，'host' 与主机共享"),
+		pod:      fs.String("pod", "", "加入pod (名称或ID)，使用pod的网络、ipc和uts namespace"),
+		workDir:  fs.String("w", "", "容器内的工作目录"),
+		user:     fs.String("u", "", "运行命令的用户 (如 'nobody' 或 '1000:1000')"),
+		hostname: fs.String("hostname", "", "容器主机名，默认为容器名称"),
+		userns:   fs.String("userns", "", "在user namespace中运行，使用 /etc/subuid 和 /etc/subgid 中该用户的从属ID"),
+		readOnly: fs.Bool("read-only", false, "只读挂载根文件系统，只有数据卷和tmpfs可写"),
+		shmSize:  fs.String("shm-size", "", "/dev/shm 的大小 (如 '256m')，默认64m"),
 	}
+	fs.Var(&opts.env, "e", "设置环境变量 (如 'KEY=VALUE'，可重复指定)")
+	fs.Var(&opts.volumes, "v", "数据卷映射 (如 '/host:/container:ro'，可重复指定)")
+	fs.Var(&opts.devices, "device", "映射主机设备 (如 '/dev/fuse' 或 '/dev/sdb:/dev/xvdb:r'，可重复指定)")
+	fs.Var(&opts.security, "security-opt", "安全选项 (如 'unmask=/proc/kcore:/proc/sys' 或 'unmask=ALL'，可重复指定)")
+	fs.Var(&opts.uidMap, "uidmap", "UID映射 (如 '0:100000:65536'，可重复指定)")
+	fs.Var(&opts.gidMap, "gidmap", "GID映射 (如 '0:100000:65536'，可重复指定，默认与UID映射相同)")
+	fs.Var(&opts.tmpfs, "tmpfs", "挂载tmpfs (如 '/run:size=64m,mode=755'，可重复指定)")
+	return opts
+}
 
-	// 获取剩余参数，第一个是镜像名，后面是要执行的命令
-	cmdArgs := runCmd.Args()
+// buildConfig 根据命令行参数构建容器配置
+// cmdArgs 为解析参数后剩余的参数，第一个是镜像名，后面是要执行的命令
+func (o *runOptions) buildConfig(cmdName string, cmdArgs []string) *container.Config {
 	if len(cmdArgs) < 1 {
-		fmt.Println("请指定容器镜像，例如: godocker run ubuntu:latest /bin/bash")
+		fmt.Printf("请指定容器镜像，例如: godocker %s ubuntu:latest /bin/bash\n", cmdName)
 		os.Exit(1)
 	}
 
 	imageName := cmdArgs[0]
 
+	volumes, err := parseVolumes(o.volumes)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	tmpfs, err := parseTmpfs(o.tmpfs)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	devices, err := parseDevices(o.devices)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	unmask, err := parseSecurityOpts(o.security)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	uidMap, err := parseIDMappings(o.uidMap)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	gidMap, err := parseIDMappings(o.gidMap)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var shmSize int64
+	if *o.shmSize != "" {
+		if shmSize, err = resources.ParseSize(*o.shmSize); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	// pod的成员使用pod的网络，没有显式指定 -net 时不使用默认的bridge网络
+	netMode := *o.network
+	if *o.pod != "" && !o.isSet("net") {
+		netMode = ""
+	}
+
 	// 构建容器配置
 	containerConfig := &container.Config{
-		Name:     *name,
+		Name:     *o.name,
 		Image:    imageName,
 		Command:  []string{},
-		Tty:      *tty,
-		Detach:   *detach,
-		Network:  *network,
-		Volumes:  parseVolumes(*volume),
-		Resource: parseResourceConfig(*memory, *cpuShare),
+		Env:      o.env,
+		WorkDir:  *o.workDir,
+		User:     *o.user,
+		Hostname: *o.hostname,
+		Tty:      *o.tty,
+		Stdin:    *o.stdin || *o.tty,
+		Network:  netMode,
+		PidMode:  *o.pid,
+		IpcMode:  *o.ipc,
+		UtsMode:  *o.uts,
+		Pod:      *o.pod,
+		Volumes:  volumes,
+		Tmpfs:    tmpfs,
+		ShmSize:  shmSize,
+		Devices:  devices,
+		Unmask:   unmask,
+		ReadOnly: *o.readOnly,
+		Userns:   *o.userns,
+		UidMap:   uidMap,
+		GidMap:   gidMap,
+		Resource: parseResourceConfig(*o.memory, *o.cpuShare),
 	}
 
 	// 处理要执行的命令
@@ -59,6 +167,39 @@ func Run(args []string) {
 		containerConfig.Command = []string{"/bin/sh"}
 	}
 
+	return containerConfig
+}
+
+// isSet 判断命令行中是否显式指定了参数
+func (o *runOptions) isSet(name string) bool {
+	set := false
+	o.flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Run 实现容器的运行命令
+func Run(args []string) {
+	// 解析run命令的参数
+	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
+
+	// 定义run命令参数
+	opts := addRunFlags(runCmd)
+	detach := runCmd.Bool("d", false, "后台运行容器")
+	detachKeys := runCmd.String("detach-keys", container.DefaultDetachKeys, "从容器分离的按键序列")
+
+	if err := runCmd.Parse(args); err != nil {
+		fmt.Println("解析参数错误:", err)
+		os.Exit(1)
+	}
+
+	// 构建容器配置
+	containerConfig := opts.buildConfig("run", runCmd.Args())
+	containerConfig.Detach = *detach
+
 	// 运行容器
 	containerId, err := container.NewContainer(containerConfig)
 	if err != nil {
@@ -66,39 +207,242 @@ func Run(args []string) {
 		os.Exit(1)
 	}
 
-	// 如果是交互式模式，等待容器运行结束
+	// 如果是前台模式，连接到容器直到容器结束或用户分离
 	if !*detach {
-		if err := container.WaitContainer(containerId); err != nil {
-			fmt.Printf("等待容器结束失败: %v\n", err)
-		}
+		attachAndExit(containerId, *detachKeys)
 	} else {
 		fmt.Printf("容器已在后台启动，ID: %s\n", containerId)
 	}
 }
 
+// Create 创建容器但不启动
+func Create(args []string) {
+	createCmd := flag.NewFlagSet("create", flag.ExitOnError)
+	opts := addRunFlags(createCmd)
+
+	if err := createCmd.Parse(args); err != nil {
+		fmt.Println("解析参数错误:", err)
+		os.Exit(1)
+	}
+
+	containerConfig := opts.buildConfig("create", createCmd.Args())
+
+	containerId, err := container.CreateContainer(containerConfig)
+	if err != nil {
+		fmt.Printf("创建容器失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(containerId)
+}
+
+// Start 启动已创建的容器
+func Start(args []string) {
+	startCmd := flag.NewFlagSet("start", flag.ExitOnError)
+	attach := startCmd.Bool("a", false, "连接容器的标准输入输出并等待容器结束")
+
+	if err := startCmd.Parse(args); err != nil {
+		fmt.Println("解析参数错误:", err)
+		os.Exit(1)
+	}
+
+	if startCmd.NArg() < 1 {
+		fmt.Println("请指定要启动的容器ID，例如: godocker start [-a] [container-id]")
+		os.Exit(1)
+	}
+
+	if *attach && startCmd.NArg() > 1 {
+		fmt.Println("使用 -a 时只能指定一个容器")
+		os.Exit(1)
+	}
+
+	for _, containerId := range startCmd.Args() {
+		if err := container.StartContainer(containerId, *attach); err != nil {
+			fmt.Printf("启动容器失败: %v\n", err)
+			os.Exit(1)
+		}
+
+		if *attach {
+			attachAndExit(containerId, container.DefaultDetachKeys)
+			return
+		}
+		fmt.Printf("容器 %s 已启动\n", containerId)
+	}
+}
+
+// attachAndExit 连接到容器的标准输入输出，容器结束后以容器的退出码退出
+func attachAndExit(containerId, detachKeys string) {
+	exitCode, err := container.AttachContainer(containerId, container.AttachOptions{
+		Stdin:      os.Stdin,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+		DetachKeys: detachKeys,
+	})
+	if errors.Is(err, container.ErrDetached) {
+		fmt.Printf("\n已从容器 %s 分离，容器继续在后台运行\n", containerId)
+		return
+	}
+	if err != nil {
+		fmt.Printf("连接容器失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	// 以容器的退出码退出，便于脚本判断执行结果
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
 // 解析卷映射参数
-func parseVolumes(volumeStr string) []container.VolumeMapping {
-	if volumeStr == "" {
-		return nil
+// 每个 -v 的格式为 主机路径或数据卷名称:容器内路径[:选项]，选项以逗号分隔，
+// 可以是 ro、rw 以及挂载传播方式 private、rprivate、shared、rshared、slave、rslave。
+// 包含 / 或以 . 开头的视为主机路径，否则视为命名数据卷
+func parseVolumes(specs []string) ([]container.VolumeMapping, error) {
+	var volumeMappings []container.VolumeMapping
+
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("无效的卷映射: %s", spec)
+		}
+
+		if !filepath.IsAbs(parts[1]) {
+			return nil, fmt.Errorf("容器内路径必须是绝对路径: %s", parts[1])
+		}
+		mapping := container.VolumeMapping{ContainerPath: filepath.Clean(parts[1])}
+
+		if strings.Contains(parts[0], "/") || strings.HasPrefix(parts[0], ".") {
+			hostPath, err := filepath.Abs(parts[0])
+			if err != nil {
+				return nil, fmt.Errorf("无效的主机路径 %s: %v", parts[0], err)
+			}
+			mapping.HostPath = hostPath
+		} else if volume.ValidName(parts[0]) {
+			mapping.Name = parts[0]
+		} else {
+			return nil, fmt.Errorf("无效的数据卷名称: %s", parts[0])
+		}
+
+		if len(parts) == 3 {
+			for _, option := range strings.Split(parts[2], ",") {
+				switch option {
+				case "ro":
+					mapping.ReadOnly = true
+				case "rw":
+					mapping.ReadOnly = false
+				case container.PropagationPrivate, container.PropagationRPrivate,
+					container.PropagationShared, container.PropagationRShared,
+					container.PropagationSlave, container.PropagationRSlave:
+					mapping.Propagation = option
+				default:
+					return nil, fmt.Errorf("不支持的卷选项 %s: %s", option, spec)
+				}
+			}
+		}
+
+		volumeMappings = append(volumeMappings, mapping)
 	}
 
-	volumeMappings := []container.VolumeMapping{}
-	volumes := strings.Split(volumeStr, ",")
+	return volumeMappings, nil
+}
 
-	for _, v := range volumes {
-		parts := strings.Split(v, ":")
+// 解析tmpfs挂载参数，格式为 容器内路径[:选项]
+func parseTmpfs(specs []string) ([]container.TmpfsMount, error) {
+	var mounts []container.TmpfsMount
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		if !filepath.IsAbs(parts[0]) {
+			return nil, fmt.Errorf("tmpfs挂载路径必须是绝对路径: %s", spec)
+		}
+
+		mount := container.TmpfsMount{Path: filepath.Clean(parts[0])}
 		if len(parts) == 2 {
-			hostPath, _ := filepath.Abs(parts[0])
-			containerPath := parts[1]
+			mount.Options = parts[1]
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts, nil
+}
 
-			volumeMappings = append(volumeMappings, container.VolumeMapping{
-				HostPath:      hostPath,
-				ContainerPath: containerPath,
-			})
+// 解析设备映射参数，格式为 主机设备[:容器内路径][:权限]
+// 权限由 r、w、m 组成，默认为 rwm，容器内路径默认与主机相同
+func parseDevices(specs []string) ([]container.DeviceMapping, error) {
+	var devices []container.DeviceMapping
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) > 3 || !filepath.IsAbs(parts[0]) {
+			return nil, fmt.Errorf("无效的设备映射: %s", spec)
+		}
+
+		device := container.DeviceMapping{
+			HostPath:      parts[0],
+			ContainerPath: parts[0],
+			Permissions:   "rwm",
+		}
+		switch {
+		case len(parts) == 3:
+			device.ContainerPath, device.Permissions = parts[1], parts[2]
+		case len(parts) == 2 && filepath.IsAbs(parts[1]):
+			device.ContainerPath = parts[1]
+		case len(parts) == 2:
+			device.Permissions = parts[1]
+		}
+
+		if !filepath.IsAbs(device.ContainerPath) {
+			return nil, fmt.Errorf("容器内设备路径必须是绝对路径: %s", spec)
+		}
+		if !validDevicePermissions(device.Permissions) {
+			return nil, fmt.Errorf("无效的设备权限 %s，只能由 r、w、m 组成且不能重复", device.Permissions)
+		}
+		device.ContainerPath = filepath.Clean(device.ContainerPath)
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// validDevicePermissions 判断设备权限是否由不重复的 r、w、m 组成
+func validDevicePermissions(permissions string) bool {
+	if permissions == "" || len(permissions) > 3 {
+		return false
+	}
+	for i, c := range permissions {
+		if !strings.ContainsRune("rwm", c) || strings.ContainsRune(permissions[i+1:], c) {
+			return false
+		}
+	}
+	return true
+}
+
+// 解析安全选项，目前支持 unmask=路径[:路径...]，取消屏蔽 /proc 和 /sys 中的内核路径
+func parseSecurityOpts(opts []string) ([]string, error) {
+	var unmask []string
+	for _, opt := range opts {
+		parts := strings.SplitN(opt, "=", 2)
+		if len(parts) != 2 || parts[0] != "unmask" || parts[1] == "" {
+			return nil, fmt.Errorf("不支持的安全选项: %s", opt)
+		}
+
+		for _, path := range strings.Split(parts[1], ":") {
+			if path != container.UnmaskAll && !filepath.IsAbs(path) {
+				return nil, fmt.Errorf("unmask 的路径必须是绝对路径或 ALL: %s", path)
+			}
+			unmask = append(unmask, filepath.Clean(path))
 		}
 	}
+	return unmask, nil
+}
 
-	return volumeMappings
+// 解析 --uidmap/--gidmap 参数
+func parseIDMappings(specs []string) ([]container.IDMapping, error) {
+	var mappings []container.IDMapping
+	for _, spec := range specs {
+		mapping, err := container.ParseIDMapping(spec)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
 }
 
 // 解析资源限制参数
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/akm/godocker/container"
+)
+
+func TestParseVolumes(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		specs   []string
+		want    []container.VolumeMapping
+		wantErr bool
+	}{
+		{"没有卷", nil, nil, false},
+		{"主机路径", []string{"/data:/app"}, []container.VolumeMapping{
+			{HostPath: "/data", ContainerPath: "/app"},
+		}, false},
+		{"只读", []string{"/data:/app:ro"}, []container.VolumeMapping{
+			{HostPath: "/data", ContainerPath: "/app", ReadOnly: true},
+		}, false},
+		{"读写", []string{"/data:/app:rw"}, []container.VolumeMapping{
+			{HostPath: "/data", ContainerPath: "/app"},
+		}, false},
+		{"后面的读写选项覆盖前面的", []string{"/data:/app:ro,rw", "/logs:/logs:rw,ro"}, []container.VolumeMapping{
+			{HostPath: "/data", ContainerPath: "/app"},
+			{HostPath: "/logs", ContainerPath: "/logs", ReadOnly: true},
+		}, false},
+		{"传播方式", []string{"/data:/app:rshared"}, []container.VolumeMapping{
+			{HostPath: "/data", ContainerPath: "/app", Propagation: container.PropagationRShared},
+		}, false},
+		{"选项列表", []string{"/data:/app:ro,slave"}, []container.VolumeMapping{
+			{HostPath: "/data", ContainerPath: "/app", ReadOnly: true, Propagation: container.PropagationSlave},
+		}, false},
+		{"多个卷", []string{"/a:/x", "/b:/y:ro"}, []container.VolumeMapping{
+			{HostPath: "/a", ContainerPath: "/x"},
+			{HostPath: "/b", ContainerPath: "/y", ReadOnly: true},
+		}, false},
+		{"路径被规范化", []string{"/data/../srv/:/app/./sub/"}, []container.VolumeMapping{
+			{HostPath: "/srv", ContainerPath: "/app/sub"},
+		}, false},
+		{"相对主机路径", []string{"./data:/app", "..:/parent"}, []container.VolumeMapping{
+			{HostPath: filepath.Join(cwd, "data"), ContainerPath: "/app"},
+			{HostPath: filepath.Dir(cwd), ContainerPath: "/parent"},
+		}, false},
+		{"包含斜杠的相对路径", []string{"data/logs:/logs"}, []container.VolumeMapping{
+			{HostPath: filepath.Join(cwd, "data/logs"), ContainerPath: "/logs"},
+		}, false},
+		{"命名数据卷", []string{"mydata:/data:ro", "v1.0_x-y:/y"}, []container.VolumeMapping{
+			{Name: "mydata", ContainerPath: "/data", ReadOnly: true},
+			{Name: "v1.0_x-y", ContainerPath: "/y"},
+		}, false},
+
+		{"缺少容器内路径", []string{"/data"}, nil, true},
+		{"空的主机路径", []string{":/app"}, nil, true},
+		{"空的容器内路径", []string{"/data:"}, nil, true},
+		{"空的选项", []string{"/data:/app:"}, nil, true},
+		{"选项列表中的空选项", []string{"/data:/app:ro,"}, nil, true},
+		{"过多的字段", []string{"/data:/app:ro:extra"}, nil, true},
+		{"容器内路径不是绝对路径", []string{"/data:app"}, nil, true},
+		{"不支持的选项", []string{"/data:/app:z"}, nil, true},
+		{"选项列表中不支持的选项", []string{"/data:/app:ro,noexec"}, nil, true},
+		{"选项大小写", []string{"/data:/app:RO"}, nil, true},
+		{"无效的数据卷名称", []string{"-data:/app"}, nil, true},
+		{"其中一个无效", []string{"/a:/x", "/b"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseVolumes(tt.specs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseVolumes(%q) error = %v, wantErr %v", tt.specs, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseVolumes(%q) = %+v, want %+v", tt.specs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTmpfs(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []string
+		want    []container.TmpfsMount
+		wantErr bool
+	}{
+		{"没有tmpfs", nil, nil, false},
+		{"只有路径", []string{"/tmp"}, []container.TmpfsMount{{Path: "/tmp"}}, false},
+		{"空的选项", []string{"/tmp:"}, []container.TmpfsMount{{Path: "/tmp"}}, false},
+		{"选项列表", []string{"/run:ro,size=64m,mode=1777"}, []container.TmpfsMount{
+			{Path: "/run", Options: "ro,size=64m,mode=1777"},
+		}, false},
+		{"选项中的冒号保留", []string{"/cache:rw,uid=1000:size=1m"}, []container.TmpfsMount{
+			{Path: "/cache", Options: "rw,uid=1000:size=1m"},
+		}, false},
+		{"路径被规范化", []string{"/var/./run/../tmp/"}, []container.TmpfsMount{{Path: "/var/tmp"}}, false},
+		{"多个tmpfs", []string{"/tmp", "/run:exec"}, []container.TmpfsMount{
+			{Path: "/tmp"},
+			{Path: "/run", Options: "exec"},
+		}, false},
+
+		{"空路径", []string{""}, nil, true},
+		{"只有选项", []string{":size=64m"}, nil, true},
+		{"相对路径", []string{"tmp:size=64m"}, nil, true},
+		{"其中一个无效", []string{"/tmp", "run"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTmpfs(tt.specs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTmpfs(%q) error = %v, wantErr %v", tt.specs, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTmpfs(%q) = %+v, want %+v", tt.specs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDevices(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []string
+		want    []container.DeviceMapping
+		wantErr bool
+	}{
+		{"没有设备", nil, nil, false},
+		{"只有主机设备", []string{"/dev/fuse"}, []container.DeviceMapping{
+			{HostPath: "/dev/fuse", ContainerPath: "/dev/fuse", Permissions: "rwm"},
+		}, false},
+		{"容器内路径", []string{"/dev/sda:/dev/xvda"}, []container.DeviceMapping{
+			{HostPath: "/dev/sda", ContainerPath: "/dev/xvda", Permissions: "rwm"},
+		}, false},
+		{"只读", []string{"/dev/sda:r"}, []container.DeviceMapping{
+			{HostPath: "/dev/sda", ContainerPath: "/dev/sda", Permissions: "r"},
+		}, false},
+		{"读写", []string{"/dev/sda:rw"}, []container.DeviceMapping{
+			{HostPath: "/dev/sda", ContainerPath: "/dev/sda", Permissions: "rw"},
+		}, false},
+		{"容器内路径和权限", []string{"/dev/sda:/dev/xvda:mr"}, []container.DeviceMapping{
+			{HostPath: "/dev/sda", ContainerPath: "/dev/xvda", Permissions: "mr"},
+		}, false},
+		{"容器内路径被规范化", []string{"/dev/sda:/dev/./disk/../xvda"}, []container.DeviceMapping{
+			{HostPath: "/dev/sda", ContainerPath: "/dev/xvda", Permissions: "rwm"},
+		}, false},
+		{"多个设备", []string{"/dev/fuse", "/dev/sda:/dev/xvda:r"}, []container.DeviceMapping{
+			{HostPath: "/dev/fuse", ContainerPath: "/dev/fuse", Permissions: "rwm"},
+			{HostPath: "/dev/sda", ContainerPath: "/dev/xvda", Permissions: "r"},
+		}, false},
+
+		{"空的设备", []string{""}, nil, true},
+		{"主机设备不是绝对路径", []string{"dev/sda"}, nil, true},
+		{"空的权限", []string{"/dev/sda:"}, nil, true},
+		{"容器内路径后空的权限", []string{"/dev/sda:/dev/xvda:"}, nil, true},
+		{"空的容器内路径", []string{"/dev/sda::rw"}, nil, true},
+		{"容器内路径不是绝对路径", []string{"/dev/sda:dev/xvda:rw"}, nil, true},
+		{"无效的权限", []string{"/dev/sda:rwx"}, nil, true},
+		{"权限大小写", []string{"/dev/sda:RW"}, nil, true},
+		{"重复的权限", []string{"/dev/sda:rr"}, nil, true},
+		{"过长的权限", []string{"/dev/sda:rwmr"}, nil, true},
+		{"过多的字段", []string{"/dev/sda:/dev/xvda:rw:extra"}, nil, true},
+		{"其中一个无效", []string{"/dev/fuse", "/dev/sda:x"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDevices(tt.specs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDevices(%q) error = %v, wantErr %v", tt.specs, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDevices(%q) = %+v, want %+v", tt.specs, got, tt.want)
+			}
+		})
+	}
+}
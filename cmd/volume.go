@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/akm/godocker/container"
+	"github.com/akm/godocker/volume"
+)
+
+// Volume 管理命名数据卷
+func Volume(args []string) {
+	if len(args) < 1 {
+		printVolumeUsage()
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "create":
+		volumeCreate(args[1:])
+	case "ls":
+		volumeList()
+	case "inspect":
+		Inspect(append([]string{"-type", "volume"}, args[1:]...))
+	case "rm":
+		volumeRemove(args[1:])
+	case "prune":
+		volumePrune()
+	default:
+		fmt.Printf("未知的volume命令: %s\n", args[0])
+		printVolumeUsage()
+		os.Exit(1)
+	}
+}
+
+func printVolumeUsage() {
+	fmt.Println("用法: godocker volume [命令]")
+	fmt.Println("\n可用命令:")
+	fmt.Println("  create   创建数据卷 (--opt type=tmpfs|ext4-image,size=SIZE 限制大小，--opt o=uid=UID,gid=GID,mode=MODE 设置tmpfs属主和权限，--label KEY=VALUE 添加标签)")
+	fmt.Println("  ls       列出数据卷")
+	fmt.Println("  inspect  查看数据卷的详细信息")
+	fmt.Println("  rm       删除数据卷")
+	fmt.Println("  prune    删除所有未被容器使用的数据卷")
+}
+
+// volumeCreate 创建数据卷
+func volumeCreate(args []string) {
+	createCmd := flag.NewFlagSet("volume create", flag.ExitOnError)
+	driver := createCmd.String("driver", volume.LocalDriver, "数据卷驱动")
+	var options, labels stringSliceFlag
+	createCmd.Var(&options, "opt", "设置驱动选项 (如 'type=tmpfs,size=64m'、'o=uid=1000,mode=770' 或 'type=ext4-image,size=1g'，可重复指定)")
+	createCmd.Var(&labels, "label", "设置数据卷标签 (如 'env=prod'，可重复指定)")
+
+	if err := createCmd.Parse(args); err != nil {
+		fmt.Println("解析参数错误:", err)
+		os.Exit(1)
+	}
+
+	// 每个 --opt 中可以用逗号分隔多个选项，o=... 的值本身以逗号分隔，整体作为一个选项
+	var optionList []string
+	for _, option := range options {
+		if strings.HasPrefix(option, "o=") {
+			optionList = append(optionList, option)
+			continue
+		}
+		optionList = append(optionList, strings.Split(option, ",")...)
+	}
+
+	vol, err := volume.Create(createCmd.Arg(0), *driver, parseKeyValues(optionList), parseKeyValues(labels))
+	if err != nil {
+		fmt.Printf("创建数据卷失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(vol.Name)
+}
+
+// parseKeyValues 把 KEY=VALUE 列表转换为map，没有等号时值为空
+func parseKeyValues(list []string) map[string]string {
+	result := make(map[string]string)
+	for _, item := range list {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) == 2 {
+			result[parts[0]] = parts[1]
+		} else {
+			result[parts[0]] = ""
+		}
+	}
+	return result
+}
+
+// volumeList 列出数据卷
+func volumeList() {
+	volumes, err := volume.List()
+	if err != nil {
+		fmt.Printf("获取数据卷列表失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-10s %-30s\n", "驱动", "数据卷名称")
+	fmt.Println("----------------------------------------------------------------------")
+	for _, vol := range volumes {
+		fmt.Printf("%-10s %-30s\n", vol.Driver, vol.Name)
+	}
+}
+
+// volumeRemove 删除数据卷
+func volumeRemove(args []string) {
+	if len(args) < 1 {
+		fmt.Println("请指定要删除的数据卷，例如: godocker volume rm [volume-name]")
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, name := range args {
+		if err := container.RemoveVolume(name); err != nil {
+			fmt.Printf("删除数据卷失败: %v\n", err)
+			failed = true
+			continue
+		}
+		fmt.Println(name)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// volumePrune 删除所有未被容器使用的数据卷
+func volumePrune() {
+	removed, err := container.PruneVolumes()
+	for _, name := range removed {
+		fmt.Println(name)
+	}
+	if err != nil {
+		fmt.Printf("清理数据卷失败: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("共删除 %d 个数据卷\n", len(removed))
+}
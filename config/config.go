@@ -0,0 +1,60 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/akm/godocker/rootless"
+)
+
+// Config godocker的全局配置
+type Config struct {
+	StorageDriver string // 存储驱动：overlay 或 vfs，为空时自动选择
+}
+
+const (
+	// 全局配置文件路径，文件不存在时使用默认配置
+	DefaultConfigPath = "/etc/godocker/config.json"
+
+	// root用户的数据根目录，容器、镜像、存储层和数据卷都保存在其下
+	DefaultDataRoot = "/var/lib/godocker"
+)
+
+// DataRoot 返回数据根目录
+// rootless模式下普通用户没有 /var/lib 的写权限，使用 $XDG_DATA_HOME/godocker，
+// 未设置 XDG_DATA_HOME 时为 ~/.local/share/godocker
+func DataRoot() string {
+	if !rootless.Enabled() {
+		return DefaultDataRoot
+	}
+
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = "/tmp"
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "godocker")
+}
+
+// Load 读取全局配置
+func Load() (*Config, error) {
+	config := &Config{}
+
+	data, err := os.ReadFile(DefaultConfigPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 失败: %v", DefaultConfigPath, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,443 @@
+package container
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 连接容器时使用的unix socket，由容器监控进程监听
+const attachSocketName = "attach.sock"
+
+// DefaultDetachKeys 默认的分离按键序列
+const DefaultDetachKeys = "ctrl-p,ctrl-q"
+
+// 监控进程与attach客户端之间的数据帧类型
+// 每一帧由1字节类型、4字节大端长度和数据组成
+const (
+	frameStdin      byte = 0 // 客户端 -> 监控进程：标准输入
+	frameStdout     byte = 1 // 监控进程 -> 客户端：标准输出
+	frameStderr     byte = 2 // 监控进程 -> 客户端：标准错误
+	frameExit       byte = 3 // 监控进程 -> 客户端：容器退出码
+	frameCloseStdin byte = 4 // 客户端 -> 监控进程：标准输入已结束
+	frameSignal     byte = 5 // 客户端 -> 监控进程：转发给容器的信号
+	frameResize     byte = 6 // 客户端 -> 监控进程：终端窗口大小
+)
+
+// 单帧数据的最大长度
+const maxFrameSize = 1 << 20
+
+// 向客户端写数据的超时时间，避免卡住的客户端阻塞容器输出
+const attachWriteTimeout = 5 * time.Second
+
+// AttachOptions 连接容器的选项
+type AttachOptions struct {
+	Stdin      io.Reader // 发送给容器的标准输入，为nil时不连接标准输入
+	Stdout     io.Writer // 容器的标准输出
+	Stderr     io.Writer // 容器的标准错误
+	DetachKeys string    // 分离按键序列，如 "ctrl-p,ctrl-q"
+}
+
+// ErrDetached 用户通过分离按键断开了与容器的连接
+var ErrDetached = errors.New("已从容器分离")
+
+// attachSocketPath 返回容器attach socket的路径
+func attachSocketPath(containerId string) string {
+	return filepath.Join(containerDir(containerId), attachSocketName)
+}
+
+// writeFrame 写入一帧数据
+func writeFrame(w io.Writer, frameType byte, data []byte) error {
+	header := make([]byte, 5)
+	header[0] = frameType
+	binary.BigEndian.PutUint32(header[1:], uint32(len(data)))
+	if _, err := w.Write(append(header, data...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readFrame 读取一帧数据
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("数据帧过大: %d", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return header[0], data, nil
+}
+
+// ParseDetachKeys 解析分离按键序列
+// 支持 "ctrl-a" 到 "ctrl-z"、"ctrl-@"、"ctrl-["、"ctrl-\"、"ctrl-]"、"ctrl-^"、"ctrl-_" 以及单个字符
+func ParseDetachKeys(keys string) ([]byte, error) {
+	if keys == "" {
+		return nil, nil
+	}
+
+	var seq []byte
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		switch {
+		case len(key) == 1:
+			seq = append(seq, key[0])
+		case len(key) == 6 && strings.HasPrefix(strings.ToLower(key), "ctrl-"):
+			c := key[5]
+			switch {
+			case c >= 'a' && c <= 'z':
+				seq = append(seq, c-'a'+1)
+			case c >= 'A' && c <= 'Z':
+				seq = append(seq, c-'A'+1)
+			case c >= '@' && c <= '_':
+				seq = append(seq, c-'@')
+			default:
+				return nil, fmt.Errorf("无效的分离按键: %s", key)
+			}
+		default:
+			return nil, fmt.Errorf("无效的分离按键: %s", key)
+		}
+	}
+
+	return seq, nil
+}
+
+// attachServer 监控进程中的attach服务，把容器输出广播给所有客户端，
+// 并把客户端的输入写入容器的标准输入
+type attachServer struct {
+	listener net.Listener
+	process  *os.Process // 容器进程，用于转发信号
+
+	stdinMu sync.Mutex
+	stdin   *os.File // 容器的标准输入，非交互式容器为nil
+	console *os.File // 容器终端的主设备，非终端模式为nil
+
+	mu      sync.Mutex
+	clients map[net.Conn]struct{}
+
+	// 第一个客户端连接时关闭，前台运行时在此之前不转发容器输出
+	firstClient chan struct{}
+	firstOnce   sync.Once
+}
+
+// newAttachServer 在容器目录下监听attach socket
+func newAttachServer(containerId string) (*attachServer, error) {
+	path := attachSocketPath(containerId)
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("监听attach socket失败: %v", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return &attachServer{
+		listener:    listener,
+		clients:     make(map[net.Conn]struct{}),
+		firstClient: make(chan struct{}),
+	}, nil
+}
+
+// serve 接受客户端连接，直到监听关闭
+func (s *attachServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.clients[conn] = struct{}{}
+		s.mu.Unlock()
+		s.firstOnce.Do(func() { close(s.firstClient) })
+
+		go s.handleClient(conn)
+	}
+}
+
+// setConsole 设置容器终端，客户端的输入写入终端主设备
+func (s *attachServer) setConsole(console *os.File) {
+	s.stdinMu.Lock()
+	defer s.stdinMu.Unlock()
+	s.stdin = console
+	s.console = console
+}
+
+// waitFirstClient 等待第一个客户端连接，超时后不再等待
+func (s *attachServer) waitFirstClient(timeout time.Duration) {
+	select {
+	case <-s.firstClient:
+	case <-time.After(timeout):
+	}
+}
+
+// handleClient 处理客户端发来的输入和信号
+func (s *attachServer) handleClient(conn net.Conn) {
+	defer s.removeClient(conn)
+
+	reader := bufio.NewReader(conn)
+	for {
+		frameType, data, err := readFrame(reader)
+		if err != nil {
+			return
+		}
+
+		switch frameType {
+		case frameStdin:
+			s.stdinMu.Lock()
+			if s.stdin != nil {
+				s.stdin.Write(data)
+			}
+			s.stdinMu.Unlock()
+		case frameCloseStdin:
+			s.closeStdin()
+		case frameSignal:
+			if sig, err := strconv.Atoi(string(data)); err == nil && s.process != nil {
+				s.process.Signal(syscall.Signal(sig))
+			}
+		case frameResize:
+			s.stdinMu.Lock()
+			if s.console != nil {
+				resizeConsole(s.console, data)
+			}
+			s.stdinMu.Unlock()
+		}
+	}
+}
+
+// closeStdin 关闭容器的标准输入
+// 终端模式下主设备同时用于输出，由监控进程在容器退出后关闭
+func (s *attachServer) closeStdin() {
+	s.stdinMu.Lock()
+	defer s.stdinMu.Unlock()
+	if s.stdin != nil && s.console == nil {
+		s.stdin.Close()
+		s.stdin = nil
+	}
+}
+
+// removeClient 断开客户端连接
+func (s *attachServer) removeClient(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.clients, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+// broadcast 把数据发送给所有客户端，写入失败的客户端会被断开
+func (s *attachServer) broadcast(frameType byte, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.clients {
+		conn.SetWriteDeadline(time.Now().Add(attachWriteTimeout))
+		if err := writeFrame(conn, frameType, data); err != nil {
+			delete(s.clients, conn)
+			conn.Close()
+		}
+	}
+}
+
+// stream 返回把数据广播为指定帧类型的函数
+func (s *attachServer) stream(frameType byte) func([]byte) {
+	return func(data []byte) {
+		s.broadcast(frameType, data)
+	}
+}
+
+// close 通知所有客户端容器已退出并关闭服务
+func (s *attachServer) close(exitCode int) {
+	s.listener.Close()
+	os.Remove(s.listener.Addr().String())
+
+	s.broadcast(frameExit, []byte(strconv.Itoa(exitCode)))
+
+	s.mu.Lock()
+	for conn := range s.clients {
+		conn.Close()
+		delete(s.clients, conn)
+	}
+	s.mu.Unlock()
+
+	s.closeStdin()
+}
+
+// AttachContainer 连接到运行中容器的标准输入输出
+// 容器退出时返回容器的退出码；用户按下分离按键时返回 ErrDetached
+func AttachContainer(containerId string, options AttachOptions) (int, error) {
+	container, err := findContainer(containerId)
+	if err != nil {
+		return ExitCodeUnknown, err
+	}
+
+	if container.Status != StatusRunning {
+		return ExitCodeUnknown, fmt.Errorf("%w: %s (%s)", ErrContainerNotRunning, container.Name, container.Status)
+	}
+
+	detachKeys, err := ParseDetachKeys(options.DetachKeys)
+	if err != nil {
+		return ExitCodeUnknown, err
+	}
+
+	conn, err := net.Dial("unix", attachSocketPath(container.ID))
+	if err != nil {
+		return ExitCodeUnknown, fmt.Errorf("连接容器失败: %v", err)
+	}
+	defer conn.Close()
+
+	var writeMu sync.Mutex
+	send := func(frameType byte, data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return writeFrame(conn, frameType, data)
+	}
+
+	// 把当前进程收到的信号转发给容器
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			send(frameSignal, []byte(strconv.Itoa(int(sig.(syscall.Signal)))))
+		}
+	}()
+
+	// 终端模式下把本地终端设为raw模式，并同步窗口大小
+	if file, ok := options.Stdin.(*os.File); ok && container.Config.Tty && isTerminal(int(file.Fd())) {
+		fd := int(file.Fd())
+		state, err := makeRaw(fd)
+		if err != nil {
+			return ExitCodeUnknown, err
+		}
+		defer restoreTerminal(fd, state)
+
+		resize := func() {
+			if data, err := encodeWinsize(fd); err == nil {
+				send(frameResize, data)
+			}
+		}
+		resize()
+
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		defer signal.Stop(winch)
+		go func() {
+			for range winch {
+				resize()
+			}
+		}()
+	}
+
+	// 非交互式容器没有标准输入；分离按键只在终端模式下检测，非终端模式的输入原样转发
+	detached := make(chan struct{})
+	if options.Stdin != nil && (container.Config.Tty || container.Config.Stdin) {
+		if !container.Config.Tty {
+			detachKeys = nil
+		}
+		go func() {
+			if copyAttachStdin(options.Stdin, detachKeys, send) {
+				close(detached)
+				return
+			}
+			send(frameCloseStdin, nil)
+		}()
+	}
+
+	// 读取容器输出直到容器退出
+	result := make(chan error, 1)
+	exitCode := ExitCodeUnknown
+	go func() {
+		reader := bufio.NewReader(conn)
+		for {
+			frameType, data, err := readFrame(reader)
+			if err != nil {
+				result <- fmt.Errorf("与容器的连接已断开: %v", err)
+				return
+			}
+			switch frameType {
+			case frameStdout:
+				options.Stdout.Write(data)
+			case frameStderr:
+				options.Stderr.Write(data)
+			case frameExit:
+				exitCode, _ = strconv.Atoi(string(data))
+				result <- nil
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-result:
+		return exitCode, err
+	case <-detached:
+		return ExitCodeUnknown, ErrDetached
+	}
+}
+
+// copyAttachStdin 把标准输入转发给容器，检测到分离按键序列时返回true
+func copyAttachStdin(stdin io.Reader, detachKeys []byte, send func(byte, []byte) error) bool {
+	buf := make([]byte, 32*1024)
+	matched := 0 // 已经匹配的分离按键数量
+
+	for {
+		n, err := stdin.Read(buf)
+		if n > 0 {
+			out := make([]byte, 0, n+matched)
+			for _, b := range buf[:n] {
+				if len(detachKeys) > 0 && b == detachKeys[matched] {
+					matched++
+					if matched == len(detachKeys) {
+						// 分离按键之前读到的输入照常发送
+						if len(out) > 0 {
+							send(frameStdin, out)
+						}
+						return true
+					}
+					continue
+				}
+				// 匹配中断，把暂存的按键原样发送
+				out = append(out, detachKeys[:matched]...)
+				matched = 0
+				if len(detachKeys) > 0 && b == detachKeys[0] {
+					matched = 1
+					continue
+				}
+				out = append(out, b)
+			}
+			if len(out) > 0 {
+				if send(frameStdin, out) != nil {
+					return false
+				}
+			}
+		}
+		if err != nil {
+			// 输入结束时还没有匹配完的按键原样发送
+			if matched > 0 {
+				send(frameStdin, detachKeys[:matched])
+			}
+			return false
+		}
+	}
+}
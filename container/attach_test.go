@@ -0,0 +1,98 @@
+package container
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseDetachKeys(t *testing.T) {
+	tests := []struct {
+		keys    string
+		want    []byte
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"ctrl-p,ctrl-q", []byte{16, 17}, false},
+		{"ctrl-a", []byte{1}, false},
+		{"ctrl-z", []byte{26}, false},
+		{"ctrl-P", []byte{16}, false},
+		{"CTRL-p", []byte{16}, false},
+		{"ctrl-@", []byte{0}, false},
+		{"ctrl-[", []byte{27}, false},
+		{`ctrl-\`, []byte{28}, false},
+		{"ctrl-]", []byte{29}, false},
+		{"ctrl-^", []byte{30}, false},
+		{"ctrl-_", []byte{31}, false},
+		{"a", []byte{'a'}, false},
+		{"ctrl-x,x", []byte{24, 'x'}, false},
+		{" ctrl-p , q ", []byte{16, 'q'}, false},
+
+		{"ctrl-", nil, true},
+		{"ctrl-1", nil, true},
+		{"ctrl-`", nil, true},
+		{"ctrl-~", nil, true},
+		{"ctrl-pq", nil, true},
+		{"ctrl+p", nil, true},
+		{"ab", nil, true},
+		{"ctrl-p,", nil, true},
+		{"ctrl-p,,ctrl-q", nil, true},
+		{",", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.keys, func(t *testing.T) {
+			got, err := ParseDetachKeys(tt.keys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDetachKeys(%q) error = %v, wantErr %v", tt.keys, err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("ParseDetachKeys(%q) = %v, want %v", tt.keys, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCopyAttachStdin(t *testing.T) {
+	ctrlPQ := []byte{16, 17}
+
+	tests := []struct {
+		name         string
+		input        io.Reader
+		detachKeys   []byte
+		wantSent     string
+		wantDetached bool
+	}{
+		{"没有分离按键", strings.NewReader("hello\x10\x11"), nil, "hello\x10\x11", false},
+		{"只有分离按键", strings.NewReader("\x10\x11"), ctrlPQ, "", true},
+		{"分离前的输入照常发送", strings.NewReader("ls\n\x10\x11rest"), ctrlPQ, "ls\n", true},
+		{"按键分多次读到", iotest.OneByteReader(strings.NewReader("ab\x10\x11")), ctrlPQ, "ab", true},
+		{"匹配中断后原样发送", strings.NewReader("\x10x\x11"), ctrlPQ, "\x10x\x11", false},
+		{"重复的第一个按键", strings.NewReader("\x10\x10\x11"), ctrlPQ, "\x10", true},
+		{"结束时未匹配完的按键", strings.NewReader("a\x10"), ctrlPQ, "a\x10", false},
+		{"单个按键", strings.NewReader("abc"), []byte{'b'}, "a", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent bytes.Buffer
+			send := func(frameType byte, data []byte) error {
+				if frameType != frameStdin {
+					t.Errorf("frame type = %d, want %d", frameType, frameStdin)
+				}
+				sent.Write(data)
+				return nil
+			}
+
+			detached := copyAttachStdin(tt.input, tt.detachKeys, send)
+			if detached != tt.wantDetached {
+				t.Errorf("detached = %v, want %v", detached, tt.wantDetached)
+			}
+			if sent.String() != tt.wantSent {
+				t.Errorf("sent = %q, want %q", sent.String(), tt.wantSent)
+			}
+		})
+	}
+}
@@ -1,20 +1,18 @@
 package container
 
 import (
-	"errors"
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
-	"strings"
+	"sort"
 	"syscall"
 	"time"
 
+	"github.com/akm/godocker/config"
 	"github.com/akm/godocker/network"
 	"github.com/akm/godocker/resources"
+	"github.com/akm/godocker/rootless"
 	"github.com/google/uuid"
-	"golang.org/x/sys/unix"
-	
 )
 
 // Config 容器配置
@@ -22,49 +20,119 @@ type Config struct {
 	Name     string                   // 容器名称
 	Image    string                   // 镜像名称
 	Command  []string                 // 容器启动命令
+	Env      []string                 // 环境变量，格式为 KEY=VALUE
+	WorkDir  string                   // 工作目录
+	User     string                   // 运行命令的用户，如 "nobody" 或 "1000:1000"
+	Hostname string                   // 主机名，为空时使用容器名称
 	Tty      bool                     // 是否启用tty
+	Stdin    bool                     // 是否保持标准输入打开，启用tty时总是打开
 	Detach   bool                     // 是否后台运行
-	Network  string                   // 网络模式
+	Network  string                   // 网络模式，"container:<ID>" 表示加入该容器的网络
+	PidMode  string                   // pid namespace，"host" 表示与主机共享，"container:<ID>" 表示加入该容器的
+	IpcMode  string                   // ipc namespace，"host" 表示与主机共享，"container:<ID>" 表示加入该容器的
+	UtsMode  string                   // uts namespace，"host" 表示与主机共享
+	Pod      string                   // 所属pod的ID，成员容器使用pod的网络、ipc和uts namespace
 	Volumes  []VolumeMapping          // 卷映射
+	Tmpfs    []TmpfsMount             // 挂载到容器中的tmpfs
+	ShmSize  int64                    // /dev/shm 的大小（字节），为0时使用 DefaultShmSize
+	Devices  []DeviceMapping          // 映射到容器中的主机设备
+	Unmask   []string                 // 不屏蔽的内核路径，"ALL" 表示全部不屏蔽，用于调试
+	ReadOnly bool                     // 只读挂载根文件系统，只有数据卷和tmpfs可写
+	Userns   string                   // 从 /etc/subuid 和 /etc/subgid 分配映射的用户，为空时按 UidMap 决定
+	UidMap   []IDMapping              // UID映射，非空时容器在自己的user namespace中运行
+	GidMap   []IDMapping              // GID映射
 	Resource resources.ResourceConfig // 资源限制
 }
 
 // VolumeMapping 卷映射
 type VolumeMapping struct {
-	HostPath      string // 主机路径
+	Name          string // 命名数据卷的名称，直接挂载主机路径时为空
+	HostPath      string // 主机路径，命名数据卷在创建容器时解析为数据卷目录
 	ContainerPath string // 容器内路径
+	ReadOnly      bool   // 是否只读挂载
+	Propagation   string // 挂载传播方式，为空时为 rprivate
 }
 
+// TmpfsMount 挂载到容器中的tmpfs
+type TmpfsMount struct {
+	Path    string // 容器内路径
+	Options string // 挂载选项，如 "size=64m,mode=1777"，默认带有 noexec,nosuid,nodev
+}
+
+// 容器 /dev/shm 的默认大小，与Docker相同
+const DefaultShmSize = 64 * 1024 * 1024
+
+// 卷映射支持的挂载传播方式
+const (
+	PropagationPrivate  = "private"
+	PropagationRPrivate = "rprivate"
+	PropagationShared   = "shared"
+	PropagationRShared  = "rshared"
+	PropagationSlave    = "slave"
+	PropagationRSlave   = "rslave"
+)
+
 // ContainerInfo 容器信息
 type ContainerInfo struct {
-	ID         string    // 容器ID
-	Name       string    // 容器名称
-	Pid        int       // 容器主进程ID
-	Image      string    // 容器镜像
-	Command    []string  // 容器启动命令
-	Status     string    // 容器状态
-	CreateTime time.Time // 容器创建时间
-	Config     Config    // 容器配置
+	ID           string    // 容器ID
+	Name         string    // 容器名称
+	Pid          int       // 容器主进程ID
+	PidStartTime uint64    // 容器主进程启动时间，用于识别PID复用
+	ShimPid      int       // 容器监控进程ID
+	ShimStart    uint64    // 容器监控进程启动时间
+	Image        string    // 容器镜像
+	Command      []string  // 容器启动命令
+	Rootfs       string    // 容器根文件系统路径，由存储驱动挂载
+	Status       Status    // 容器状态
+	ExitCode     int       // 最近一次退出的状态码
+	OOMKilled    bool      // 是否因内存不足被杀死
+	CreateTime   time.Time // 容器创建时间
+	StartedAt    time.Time // 最近一次启动时间
+	FinishedAt   time.Time // 最近一次退出时间
+	Config       Config    // 容器配置
+
+	StorageDriver  string                 // 保存容器可写层的存储驱动
+	IDMappedRootfs bool                   // 镜像层是否按容器的ID映射以idmapped方式挂载
+	Network        *network.NetworkConfig // 容器网络配置
 }
 
+// 容器数据根目录，每个容器一个以ID命名的子目录
+var DefaultContainerRoot = config.DataRoot()
+
 const (
-	DefaultContainerRoot = "/var/lib/godocker"
-	StatusRunning        = "运行中"
-	StatusStopped        = "已停止"
+	// 停止容器时等待进程退出的时间，超时后强制终止
+	DefaultStopTimeout = 10 * time.Second
 )
 
-// 运行中的容器映射表
-var runningContainers = make(map[string]*ContainerInfo)
-
 // NewContainer 创建并启动一个新的容器
 func NewContainer(config *Config) (string, error) {
+	containerId, err := CreateContainer(config)
+	if err != nil {
+		return "", err
+	}
+
+	// 前台运行时将容器的标准输入输出连接到当前终端
+	if err := StartContainer(containerId, !config.Detach); err != nil {
+		return "", err
+	}
+
+	return containerId, nil
+}
+
+// CreateContainer 创建容器
+// 准备容器文件系统、预留网络资源并保存容器配置，但不启动容器进程
+func CreateContainer(config *Config) (string, error) {
 	// 生成唯一的容器ID
 	containerId := generateContainerId()
 
 	// 如果指定了容器名称，检查是否重复
 	if config.Name != "" {
 		// 检查同名容器是否存在
-		for _, c := range runningContainers {
+		containers, err := loadAllContainers()
+		if err != nil {
+			return "", err
+		}
+		for _, c := range containers {
 			if c.Name == config.Name {
 				return "", fmt.Errorf("已存在同名容器: %s", config.Name)
 			}
@@ -74,10 +142,26 @@ func NewContainer(config *Config) (string, error) {
 		config.Name = containerId[:12]
 	}
 
-	// 准备容器文件系统
-	containerRoot, err := prepareRootfs(containerId, config.Image)
-	if err != nil {
-		return "", fmt.Errorf("准备容器文件系统失败: %v", err)
+	// 检查要与主机或其他容器共享的namespace
+	if err := resolveNamespaceModes(config); err != nil {
+		return "", err
+	}
+	if config.Hostname == "" && config.isolatesNamespace("uts") {
+		config.Hostname = config.Name
+	}
+
+	// 读取要映射到容器中的主机设备
+	if err := resolveDevices(config.Devices); err != nil {
+		return "", err
+	}
+
+	// 确定user namespace的ID映射
+	if err := resolveUserns(config); err != nil {
+		return "", err
+	}
+
+	if rootless.Enabled() && (config.Resource.MemoryLimit != "" || config.Resource.CpuSet != "" || config.Resource.CpuShare > 0) {
+		fmt.Println("警告: rootless模式下无法使用cgroup，内存和CPU限制不会生效")
 	}
 
 	// 创建容器记录
@@ -86,137 +170,206 @@ func NewContainer(config *Config) (string, error) {
 		Name:       config.Name,
 		Image:      config.Image,
 		Command:    config.Command,
-		Status:     StatusRunning,
+		Status:     StatusCreated,
 		CreateTime: time.Now(),
 		Config:     *config,
 	}
 
-	// 启动容器进程
-	process, err := startContainer(container, containerRoot)
-	if err != nil {
-		return "", fmt.Errorf("启动容器进程失败: %v", err)
+	// 准备容器文件系统
+	if err := prepareRootfs(container); err != nil {
+		removeContainerDir(container)
+		return "", fmt.Errorf("准备容器文件系统失败: %v", err)
 	}
 
-	// 记录进程ID
-	container.Pid = process.Pid
-
-	// 保存容器信息
-	runningContainers[containerId] = container
-
-	// 应用资源限制
-	if err := resources.ApplyResourceLimits(process.Pid, config.Resource); err != nil {
-		fmt.Printf("警告: 应用资源限制失败: %v\n", err)
+	// 解析命名数据卷，需要在根文件系统准备好之后进行，以便用镜像内容填充新数据卷
+	if err := resolveVolumes(container); err != nil {
+		removeContainerDir(container)
+		return "", err
 	}
 
-	if config.Network != "" && config.Network != "none" {
-		_, err := network.SetupNetwork(config.Network, containerId, container.Pid)
+	// 预留网络资源
+	if config.Network != "" {
+		netConfig, err := network.ReserveNetwork(config.Network, containerId)
 		if err != nil {
-			fmt.Printf("容器网络配置失败: %v\n", err)
+			removeContainerDir(container)
+			return "", fmt.Errorf("预留容器网络失败: %v", err)
 		}
+		container.Network = netConfig
 	}
+
+	// 保存容器信息
+	if err := saveContainerInfo(container); err != nil {
+		network.ReleaseNetwork(container.Network, containerId)
+		removeContainerDir(container)
+		return "", err
+	}
+
 	return containerId, nil
 }
 
-// StopContainer 停止容器
-func StopContainer(containerId string) error {
-	container, exists := runningContainers[containerId]
-	if !exists {
-		return fmt.Errorf("找不到容器: %s", containerId)
+// StartContainer 启动已创建或已退出的容器
+// attach为true时容器的标准输入输出连接到当前进程的终端
+func StartContainer(containerId string, attach bool) error {
+	container, err := findContainer(containerId)
+	if err != nil {
+		return err
 	}
 
-	// 如果容器已停止，直接返回
-	if container.Status == StatusStopped {
-		return nil
+	if container.Status.IsActive() {
+		return fmt.Errorf("%w: %s", ErrContainerRunning, container.Name)
+	}
+	if !container.Status.CanTransition(StatusRunning) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, container.Status, StatusRunning)
+	}
+
+	// pod的成员启动前先启动pod的infra进程
+	if container.Config.Pod != "" {
+		if err := ensurePodRunning(container.Config.Pod); err != nil {
+			return fmt.Errorf("启动pod失败: %v", err)
+		}
 	}
 
-	// 向容器主进程发送SIGTERM信号
-	process, err := os.FindProcess(container.Pid)
+	// 由监控进程启动并看护容器进程，当前进程退出后容器继续运行
+	pid, err := startShim(container, attach)
 	if err != nil {
-		return fmt.Errorf("查找容器进程失败: %v", err)
+		return fmt.Errorf("启动容器进程失败: %v", err)
+	}
+
+	fmt.Printf("容器进程已启动，PID: %d\n", pid)
+
+	return nil
+}
+
+// StopContainer 停止容器
+// 先发送SIGTERM，超过 DefaultStopTimeout 仍未退出则发送SIGKILL
+func StopContainer(containerId string) error {
+	container, err := findContainer(containerId)
+	if err != nil {
+		return err
+	}
+
+	if !container.Status.IsActive() {
+		return fmt.Errorf("%w: %s (%s)", ErrContainerNotRunning, container.Name, container.Status)
 	}
 
 	// 先尝试优雅停止
-	if err := process.Signal(syscall.SIGTERM); err != nil {
+	if err := syscall.Kill(container.Pid, syscall.SIGTERM); err != nil {
 		fmt.Printf("发送SIGTERM信号失败，尝试强制终止: %v\n", err)
-		// 如果SIGTERM失败，强制终止
-		if err := process.Kill(); err != nil {
+	}
+
+	// 超时后强制终止，退出码和退出时间由监控进程记录
+	if !waitContainerExit(container.ID, DefaultStopTimeout) {
+		if err := syscall.Kill(container.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
 			return fmt.Errorf("终止容器进程失败: %v", err)
 		}
+		if !waitContainerExit(container.ID, DefaultStopTimeout) {
+			return fmt.Errorf("等待容器 %s 退出超时", container.Name)
+		}
 	}
 
-	// 更新容器状态
-	container.Status = StatusStopped
-
 	return nil
 }
 
 // RemoveContainer 删除容器
-func RemoveContainer(containerId string) error {
-	container, exists := runningContainers[containerId]
-	if !exists {
-		return fmt.Errorf("找不到容器: %s", containerId)
+// 容器仍在运行时返回 ErrContainerRunning，force为true时先停止容器
+func RemoveContainer(containerId string, force bool) error {
+	container, err := findContainer(containerId)
+	if err != nil {
+		return err
 	}
 
-	// 如果容器仍在运行，先停止
-	if container.Status == StatusRunning {
-		if err := StopContainer(containerId); err != nil {
+	// 其他容器加入了它的namespace时，删除后这些容器就无法再启动
+	users, err := namespaceUsers(container.ID)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return fmt.Errorf("%w: %s %v", ErrNamespaceInUse, container.Name, users)
+	}
+
+	if container.Status.IsActive() {
+		if !force {
+			return fmt.Errorf("%w: %s，请先停止容器或使用 -f 强制删除", ErrContainerRunning, container.Name)
+		}
+		if err := StopContainer(container.ID); err != nil {
 			return fmt.Errorf("停止容器失败: %v", err)
 		}
 	}
 
-	// 清理容器文件系统
-	containerRoot := filepath.Join(DefaultContainerRoot, containerId)
-	if err := os.RemoveAll(containerRoot); err != nil {
-		fmt.Printf("警告: 清理容器文件系统失败: %v\n", err)
+	// 释放预留的网络资源
+	if err := network.ReleaseNetwork(container.Network, container.ID); err != nil {
+		fmt.Printf("警告: 释放容器网络失败: %v\n", err)
 	}
 
-	// 从运行列表中删除
-	delete(runningContainers, containerId)
+	// 卸载并清理容器文件系统和状态
+	if err := removeContainerDir(container); err != nil {
+		fmt.Printf("警告: 清理容器文件系统失败: %v\n", err)
+	}
 
 	return nil
 }
 
 // ListContainers 列出所有容器
 func ListContainers() ([]*ContainerInfo, error) {
-	result := make([]*ContainerInfo, 0, len(runningContainers))
-
-	for _, container := range runningContainers {
-		result = append(result, container)
+	containers, err := loadAllContainers()
+	if err != nil {
+		return nil, err
 	}
 
-	return result, nil
+	// 按创建时间排序，最新的容器在前
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].CreateTime.After(containers[j].CreateTime)
+	})
+
+	return containers, nil
 }
 
-// WaitContainer 等待容器执行结束
-func WaitContainer(containerId string) error {
-	container, exists := runningContainers[containerId]
-	if !exists {
-		return fmt.Errorf("找不到容器: %s", containerId)
+// WaitContainer 等待容器执行结束，返回容器的退出码
+func WaitContainer(containerId string) (int, error) {
+	container, err := findContainer(containerId)
+	if err != nil {
+		return ExitCodeUnknown, err
 	}
 
 	// 如果容器已停止，直接返回
-	if container.Status == StatusStopped {
-		return nil
+	if !container.Status.IsActive() {
+		return container.ExitCode, nil
 	}
 
-	// 查找容器进程
-	process, err := os.FindProcess(container.Pid)
-	if err != nil {
-		return fmt.Errorf("查找容器进程失败: %v", err)
+	// 容器进程由监控进程回收，这里只需要等待监控进程记录退出状态
+	for {
+		latest, err := loadContainerInfo(container.ID)
+		if err != nil {
+			return ExitCodeUnknown, err
+		}
+		if !latest.Status.IsActive() {
+			return latest.ExitCode, nil
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
+}
 
-	// 等待进程结束
-	state, err := process.Wait()
-	if err != nil {
-		return fmt.Errorf("等待容器进程失败: %v", err)
+// waitContainerExit 在超时时间内等待容器退出，返回容器是否已退出
+func waitContainerExit(containerId string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		latest, err := loadContainerInfo(containerId)
+		if err != nil || !latest.Status.IsActive() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
+}
 
-	// 更新容器状态
-	container.Status = StatusStopped
-
-	fmt.Printf("容器 %s 已退出，状态码: %d\n", containerId[:12], state.ExitCode())
-
-	return nil
+// exitCodeFromState 计算进程退出码，被信号终止时按惯例返回128+信号值
+func exitCodeFromState(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
 }
 
 // 生成唯一的容器ID
@@ -224,31 +377,23 @@ func generateContainerId() string {
 	return uuid.New().String()
 }
 
-// 准备容器文件系统
-func prepareRootfs(containerId, imageName string) (string, error) {
-	// 容器根目录
-	containerRoot := filepath.Join(DefaultContainerRoot, containerId)
-
-	// 创建容器目录
-	if err := os.MkdirAll(containerRoot, 0755); err != nil {
-		return "", err
-	}
-
-	// TODO: 实际解压镜像到该目录，这里简化为使用主机的文件系统
-	fmt.Printf("准备容器文件系统: %s (使用镜像: %s)\n", containerRoot, imageName)
-
-	// 在实际实现中，这里应该解压镜像到containerRoot目录
-	// 简化示例中，我们创建一个简单的文件表示rootfs已准备
-	marker := filepath.Join(containerRoot, ".rootfs_ready")
-	if err := os.WriteFile(marker, []byte(imageName), 0644); err != nil {
-		return "", err
-	}
-
-	return containerRoot, nil
+// containerStdio 容器进程的标准输入输出
+type containerStdio struct {
+	stdin   *os.File // 非终端模式下保持打开的标准输入，否则为nil
+	stdout  *os.File
+	stderr  *os.File
+	console *os.File // 传递终端主设备的socket，非终端模式为nil
 }
 
 // 启动容器进程
-func startContainer(container *ContainerInfo, rootfs string) (*os.Process, error) {
+// init进程启动后阻塞在配置管道上，调用方准备好容器环境后再通过返回的管道写入 initConfig
+func startContainer(container *ContainerInfo, stdio containerStdio) (*exec.Cmd, *os.File, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("创建配置管道失败: %v", err)
+	}
+	defer reader.Close()
+
 	// 设置命令
 	cmd := exec.Command("/proc/self/exe", "init")
 
@@ -256,99 +401,40 @@ func startContainer(container *ContainerInfo, rootfs string) (*os.Process, error
 	cmd.SysProcAttr = &syscall.SysProcAttr{}
 
 	// 平台特定的namespace设置
-	setNamespaceFlags(cmd.SysProcAttr)
-
-	// 设置环境变量
-	cmd.Env = []string{
-		"PATH=/bin:/usr/bin:/sbin:/usr/sbin",
-		"TERM=xterm",
-		"CONTAINER_ID=" + container.ID,
-		"CONTAINER_NAME=" + container.Name,
-	}
-
-	// 传递容器配置
-	cmd.Env = append(cmd.Env,
-		"CONTAINER_CMD="+strings.Join(container.Command, " "),
-		"CONTAINER_ROOTFS="+rootfs,
-	)
-
-	// 设置标准输入输出
-	if container.Config.Tty {
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	}
-
-	// 启动进程
-	if err := cmd.Start(); err != nil {
-		return nil, err
-	}
-
-	fmt.Printf("容器进程已启动，PID: %d\n", cmd.Process.Pid)
-
-	return cmd.Process, nil
-}
-
-// 设置挂载点
-func setupMounts(rootfs string) error {
-	// 实现文件系统挂载
-	// 这里需要挂载proc、sys等文件系统
-
-	// 示例: 挂载proc文件系统
-	procPath := filepath.Join(rootfs, "proc")
-	if err := os.MkdirAll(procPath, 0755); err != nil {
-		return err
-	}
+	setNamespaceFlags(cmd.SysProcAttr, &container.Config)
 
-	if err := unix.Mount("proc", procPath, "proc", 0, ""); err != nil {
-		return err
-	}
-
-	return nil
-}
+	// 容器配置全部通过管道传递，init进程不继承监控进程的环境变量
+	cmd.Env = []string{}
 
-// 容器初始化函数，会在容器命名空间中运行
-func containerInitProcess() error {
-	// 获取环境变量中的容器配置
-	rootfs := os.Getenv("CONTAINER_ROOTFS")
-	cmdString := os.Getenv("CONTAINER_CMD")
-
-	if rootfs == "" || cmdString == "" {
-		return errors.New("缺少必要的容器环境配置")
-	}
-
-	// 设置主机名
-	if err := unix.Sethostname([]byte(os.Getenv("CONTAINER_NAME"))); err != nil {
-		return err
+	// 配置管道是文件描述符3，终端模式下传递终端主设备的socket是文件描述符4
+	cmd.ExtraFiles = []*os.File{reader}
+	if stdio.console != nil {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, stdio.console)
 	}
 
-	// 设置挂载点
-	if err := setupMounts(rootfs); err != nil {
-		return err
+	// 设置标准输入输出，为nil的文件不能直接赋值给接口，否则会被当作有效的Reader
+	if stdio.stdin != nil {
+		cmd.Stdin = stdio.stdin
 	}
-
-	// 切换根目录
-	if err := unix.Chroot(rootfs); err != nil {
-		return err
+	if stdio.stdout != nil {
+		cmd.Stdout = stdio.stdout
 	}
-
-	// 切换工作目录
-	if err := os.Chdir("/"); err != nil {
-		return err
+	if stdio.stderr != nil {
+		cmd.Stderr = stdio.stderr
 	}
 
-	// 解析命令
-	cmdParts := strings.Split(cmdString, " ")
-	if len(cmdParts) == 0 {
-		return errors.New("无效的容器命令")
+	// 要加入的其他容器的namespace
+	namespaces, err := joinedNamespacePaths(&container.Config)
+	if err != nil {
+		writer.Close()
+		return nil, nil, err
 	}
 
-	// 查找命令路径
-	cmdPath, err := exec.LookPath(cmdParts[0])
-	if err != nil {
-		return err
+	// 启动进程
+	if err := startInNamespaces(cmd, namespaces); err != nil {
+		writer.Close()
+		return nil, nil, err
 	}
 
-	// 执行命令
-	return syscall.Exec(cmdPath, cmdParts, os.Environ())
+	return cmd, writer, nil
 }
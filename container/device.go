@@ -0,0 +1,119 @@
+package container
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/akm/godocker/resources"
+	"golang.org/x/sys/unix"
+)
+
+// DeviceMapping 容器中的设备文件
+type DeviceMapping struct {
+	HostPath      string // 主机上的设备文件，标准设备为空
+	ContainerPath string // 容器内的设备文件路径
+	Permissions   string // 设备cgroup权限，r读、w写、m创建设备文件
+	Type          string // 设备类型，c为字符设备，b为块设备
+	Major         int64  // 主设备号
+	Minor         int64  // 次设备号
+	FileMode      uint32 // 设备文件权限
+	Uid           uint32 // 设备文件属主
+	Gid           uint32 // 设备文件属组
+}
+
+// 每个容器的 /dev 中都会创建的标准设备
+var defaultDevices = []DeviceMapping{
+	{HostPath: "/dev/null", ContainerPath: "/dev/null", Type: "c", Major: 1, Minor: 3, FileMode: 0666, Permissions: "rwm"},
+	{HostPath: "/dev/zero", ContainerPath: "/dev/zero", Type: "c", Major: 1, Minor: 5, FileMode: 0666, Permissions: "rwm"},
+	{HostPath: "/dev/full", ContainerPath: "/dev/full", Type: "c", Major: 1, Minor: 7, FileMode: 0666, Permissions: "rwm"},
+	{HostPath: "/dev/random", ContainerPath: "/dev/random", Type: "c", Major: 1, Minor: 8, FileMode: 0666, Permissions: "rwm"},
+	{HostPath: "/dev/urandom", ContainerPath: "/dev/urandom", Type: "c", Major: 1, Minor: 9, FileMode: 0666, Permissions: "rwm"},
+	{HostPath: "/dev/tty", ContainerPath: "/dev/tty", Type: "c", Major: 5, Minor: 0, FileMode: 0666, Permissions: "rwm"},
+}
+
+// /dev 中指向其他位置的符号链接，链接名 -> 目标
+var defaultDeviceLinks = [][2]string{
+	{"/dev/ptmx", "pts/ptmx"},
+	{"/dev/fd", "/proc/self/fd"},
+	{"/dev/stdin", "/proc/self/fd/0"},
+	{"/dev/stdout", "/proc/self/fd/1"},
+	{"/dev/stderr", "/proc/self/fd/2"},
+}
+
+// resolveDevices 读取 --device 指定的主机设备的类型、设备号和权限
+func resolveDevices(devices []DeviceMapping) error {
+	for i := range devices {
+		device := &devices[i]
+
+		var st unix.Stat_t
+		if err := unix.Stat(device.HostPath, &st); err != nil {
+			return fmt.Errorf("读取设备 %s 失败: %v", device.HostPath, err)
+		}
+
+		switch st.Mode & unix.S_IFMT {
+		case unix.S_IFCHR:
+			device.Type = "c"
+		case unix.S_IFBLK:
+			device.Type = "b"
+		default:
+			return fmt.Errorf("%s 不是设备文件", device.HostPath)
+		}
+
+		device.Major = int64(unix.Major(uint64(st.Rdev)))
+		device.Minor = int64(unix.Minor(uint64(st.Rdev)))
+		device.FileMode = st.Mode & 07777
+		device.Uid = st.Uid
+		device.Gid = st.Gid
+	}
+	return nil
+}
+
+// deviceRules 生成容器的设备cgroup规则，只允许访问标准设备和 --device 指定的设备
+func deviceRules(devices []DeviceMapping) []resources.DeviceRule {
+	rules := []resources.DeviceRule{
+		// 允许创建任何设备文件，能否读写由下面的规则决定
+		{Type: "c", Major: resources.AnyDevice, Minor: resources.AnyDevice, Permissions: "m"},
+		{Type: "b", Major: resources.AnyDevice, Minor: resources.AnyDevice, Permissions: "m"},
+		// 容器devpts实例中的 /dev/pts/ptmx 和伪终端从设备
+		{Type: "c", Major: 5, Minor: 2, Permissions: "rwm"},
+		{Type: "c", Major: 136, Minor: resources.AnyDevice, Permissions: "rwm"},
+	}
+
+	for _, device := range append(defaultDevices, devices...) {
+		rules = append(rules, resources.DeviceRule{
+			Type:        device.Type,
+			Major:       device.Major,
+			Minor:       device.Minor,
+			Permissions: device.Permissions,
+		})
+	}
+	return rules
+}
+
+// createDevice 在容器中创建设备文件
+// user namespace中不允许mknod，改为把主机的设备文件绑定挂载到容器中
+func createDevice(path string, device DeviceMapping, userns bool) error {
+	if userns {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+		file.Close()
+		return mountFilesystem(device.HostPath, path, "", unix.MS_BIND, "")
+	}
+
+	mode := uint32(unix.S_IFCHR)
+	if device.Type == "b" {
+		mode = unix.S_IFBLK
+	}
+
+	dev := int(unix.Mkdev(uint32(device.Major), uint32(device.Minor)))
+	if err := unix.Mknod(path, mode|device.FileMode, dev); err != nil && !os.IsExist(err) {
+		return err
+	}
+	// mknod的权限受umask影响，重新设置一次
+	if err := unix.Chmod(path, device.FileMode); err != nil {
+		return err
+	}
+	return unix.Chown(path, int(device.Uid), int(device.Gid))
+}
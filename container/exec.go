@@ -0,0 +1,356 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/akm/godocker/container/nsenter"
+	"github.com/akm/godocker/resources"
+)
+
+// ExecConfig 在运行中的容器内执行命令的配置
+type ExecConfig struct {
+	Command     []string // 要执行的命令及参数
+	Env         []string // 额外的环境变量，格式为 KEY=VALUE
+	User        string   // 执行命令的用户，如 "nobody" 或 "1000:1000"
+	WorkDir     string   // 工作目录
+	Interactive bool     // 是否连接标准输入
+	Tty         bool     // 是否启用终端
+}
+
+const (
+	// 默认的PATH环境变量
+	defaultPathEnv = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+	// 父进程通过该文件描述符向子进程传递配置（ExtraFiles中的第一个文件）
+	configPipeFd = 3
+)
+
+// exec进程需要加入的namespace，mnt必须放在最后
+// user必须放在最前面：容器使用user namespace或以rootless模式运行时，
+// 其他namespace都属于容器的user namespace，需要先加入它才有权限加入；
+// 与当前进程相同时nsenter会跳过，内核不支持的namespace在启动前去掉
+var execNamespaces = []string{"user", "ipc", "uts", "net", "pid", "cgroup", "time", "mnt"}
+
+// ExecContainer 在运行中的容器内执行命令，返回命令的退出码
+func ExecContainer(containerId string, config *ExecConfig) (int, error) {
+	if len(config.Command) == 0 {
+		return ExitCodeUnknown, fmt.Errorf("请指定要执行的命令")
+	}
+
+	if !nsenter.Supported() {
+		return ExitCodeUnknown, fmt.Errorf("当前构建不支持exec，需要在Linux上启用cgo编译")
+	}
+
+	container, err := findContainer(containerId)
+	if err != nil {
+		return ExitCodeUnknown, err
+	}
+
+	if container.Status != StatusRunning {
+		return ExitCodeUnknown, fmt.Errorf("%w: %s (%s)", ErrContainerNotRunning, container.Name, container.Status)
+	}
+
+	// 补充容器的默认环境变量
+	execConfig := *config
+	execConfig.Env = append(defaultEnv(containerHostname(container), config.Tty), container.Config.Env...)
+	execConfig.Env = append(execConfig.Env, config.Env...)
+
+	// 通过管道传递exec配置，子进程在启动时由nsenter加入容器的cgroup和namespace
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return ExitCodeUnknown, fmt.Errorf("创建配置管道失败: %v", err)
+	}
+	defer reader.Close()
+
+	cmd := exec.Command("/proc/self/exe", "nsexec")
+	cmd.ExtraFiles = []*os.File{reader}
+	cmd.Env = []string{
+		nsenter.EnvPid + "=" + strconv.Itoa(container.Pid),
+		nsenter.EnvNamespaces + "=" + strings.Join(supportedNamespaces(execNamespaces), ","),
+		nsenter.EnvRoot + "=1",
+		nsenter.EnvCgroups + "=" + strings.Join(resources.CgroupProcsPaths(container.Pid), ","),
+	}
+
+	// 本地终端不能直接交给容器内的进程，否则容器内的进程可以通过TIOCSTI向终端注入输入。
+	// 终端模式下在容器内分配伪终端，由exec进程通过socket发回主设备
+	var consoleSocket, consoleChild *os.File
+	if config.Tty {
+		if consoleSocket, consoleChild, err = newConsoleSocket(); err != nil {
+			writer.Close()
+			return ExitCodeUnknown, err
+		}
+		defer consoleSocket.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, consoleChild)
+	} else {
+		cmd.Stdout = pipeIfTerminal(os.Stdout)
+	}
+	cmd.Stderr = pipeIfTerminal(os.Stderr)
+
+	var stdin io.WriteCloser
+	if config.Interactive && !config.Tty {
+		if isTerminal(int(os.Stdin.Fd())) {
+			if stdin, err = cmd.StdinPipe(); err != nil {
+				writer.Close()
+				return ExitCodeUnknown, fmt.Errorf("创建标准输入管道失败: %v", err)
+			}
+		} else {
+			cmd.Stdin = os.Stdin
+		}
+	}
+
+	err = cmd.Start()
+	if consoleChild != nil {
+		consoleChild.Close()
+	}
+	if err != nil {
+		writer.Close()
+		return ExitCodeUnknown, fmt.Errorf("启动exec进程失败: %v", err)
+	}
+	if stdin != nil {
+		// 进程退出后 Wait 会关闭管道，不等待这里的复制结束
+		go func() {
+			io.Copy(stdin, os.Stdin)
+			stdin.Close()
+		}()
+	}
+
+	err = writeConfigPipe(writer, &execConfig)
+	writer.Close()
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return ExitCodeUnknown, err
+	}
+
+	// 终端模式下由容器内的进程处理中断信号
+	signal.Ignore(syscall.SIGINT, syscall.SIGQUIT)
+	defer signal.Reset(syscall.SIGINT, syscall.SIGQUIT)
+
+	var outputDone chan struct{}
+	if consoleSocket != nil {
+		console, err := receiveConsole(consoleSocket)
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return ExitCodeUnknown, err
+		}
+		defer console.Close()
+
+		restore, err := proxyConsole(console, config.Interactive)
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return ExitCodeUnknown, err
+		}
+		defer restore()
+
+		outputDone = make(chan struct{})
+		go func() {
+			// 容器内的进程全部关闭从设备后读取返回EIO
+			io.Copy(os.Stdout, console)
+			close(outputDone)
+		}()
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return ExitCodeUnknown, fmt.Errorf("等待exec进程失败: %v", err)
+		}
+	}
+
+	// 输出剩余的终端内容；后台进程仍然持有终端时不再等待
+	if outputDone != nil {
+		select {
+		case <-outputDone:
+		case <-time.After(time.Second):
+		}
+	}
+
+	return exitCodeFromState(cmd.ProcessState), nil
+}
+
+// proxyConsole 把本地终端连接到exec进程的伪终端：设为raw模式、同步窗口大小并转发输入
+// 返回的函数用于恢复本地终端
+func proxyConsole(console *os.File, interactive bool) (func(), error) {
+	restore := func() {}
+
+	fd := int(os.Stdin.Fd())
+	if isTerminal(fd) {
+		state, err := makeRaw(fd)
+		if err != nil {
+			return nil, err
+		}
+
+		resize := func() {
+			if data, err := encodeWinsize(fd); err == nil {
+				resizeConsole(console, data)
+			}
+		}
+		resize()
+
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		go func() {
+			for range winch {
+				resize()
+			}
+		}()
+
+		restore = func() {
+			signal.Stop(winch)
+			restoreTerminal(fd, state)
+		}
+	}
+
+	if interactive {
+		go io.Copy(console, os.Stdin)
+	}
+	return restore, nil
+}
+
+// pipeIfTerminal 输出是终端时通过管道转发，避免把终端交给容器内的进程
+func pipeIfTerminal(f *os.File) io.Writer {
+	if isTerminal(int(f.Fd())) {
+		return struct{ io.Writer }{f}
+	}
+	return f
+}
+
+// RunExecProcess 在容器的namespace中执行命令
+// 由 ExecContainer 启动的子进程调用，此时已经加入容器的namespace并切换到容器根目录
+func RunExecProcess() error {
+	if os.Getenv(nsenter.EnvPid) == "" {
+		return fmt.Errorf("缺少目标容器进程")
+	}
+
+	var config ExecConfig
+	if err := readConfigPipe(&config); err != nil {
+		return err
+	}
+
+	user, err := lookupUser(config.User)
+	if err != nil {
+		return err
+	}
+
+	// 终端模式下在容器的 /dev/pts 中分配伪终端，作为命令的控制终端和标准输入输出
+	if config.Tty {
+		if err := setupConsole(consoleSocketFd); err != nil {
+			return fmt.Errorf("创建终端失败: %v", err)
+		}
+	}
+
+	env := append([]string{"HOME=" + user.Home}, config.Env...)
+
+	// 使用容器内的PATH查找命令
+	cmdPath, err := lookPathIn(config.Command[0], env)
+	if err != nil {
+		return fmt.Errorf("找不到命令 %s: %v", config.Command[0], err)
+	}
+
+	// 创建子进程执行命令，以便以指定用户身份运行并转发信号
+	cmd := exec.Command(cmdPath, config.Command[1:]...)
+	cmd.Args[0] = config.Command[0]
+	cmd.Env = env
+	cmd.Dir = config.WorkDir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: user.Uid, Gid: user.Gid, NoSetGroups: setgroupsDenied()},
+	}
+	if cmd.Dir == "" {
+		cmd.Dir = "/"
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("执行命令失败: %v", err)
+	}
+
+	// 将收到的信号转发给容器内的进程
+	// 终端产生的SIGINT和SIGQUIT会直接发给同一进程组的容器进程，这里不再转发。
+	// 注意不能用signal.Ignore，被忽略的信号会被子进程继承
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGINT || sig == syscall.SIGQUIT || sig == syscall.SIGCHLD || sig == syscall.SIGURG {
+				continue
+			}
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	cmd.Wait()
+	os.Exit(exitCodeFromState(cmd.ProcessState))
+	return nil
+}
+
+// defaultEnv 容器内进程的默认环境变量
+func defaultEnv(hostname string, tty bool) []string {
+	env := []string{
+		defaultPathEnv,
+		"HOSTNAME=" + hostname,
+	}
+	if tty {
+		env = append(env, "TERM=xterm")
+	}
+	return env
+}
+
+// lookPathIn 根据环境变量中的PATH查找可执行文件
+func lookPathIn(file string, env []string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+
+	path := strings.TrimPrefix(defaultPathEnv, "PATH=")
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			path = strings.TrimPrefix(kv, "PATH=")
+		}
+	}
+
+	for _, dir := range strings.Split(path, ":") {
+		if dir == "" {
+			dir = "."
+		}
+		candidate := dir + "/" + file
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return candidate, nil
+		}
+	}
+
+	return "", exec.ErrNotFound
+}
+
+// writeConfigPipe 将配置以JSON格式写入管道
+func writeConfigPipe(writer *os.File, v interface{}) error {
+	if err := json.NewEncoder(writer).Encode(v); err != nil {
+		return fmt.Errorf("传递配置失败: %v", err)
+	}
+	return nil
+}
+
+// readConfigPipe 从父进程传递的管道中读取配置
+func readConfigPipe(v interface{}) error {
+	pipe := os.NewFile(uintptr(configPipeFd), "config-pipe")
+	if pipe == nil {
+		return fmt.Errorf("无法打开配置管道")
+	}
+	defer pipe.Close()
+
+	if err := json.NewDecoder(pipe).Decode(v); err != nil {
+		return fmt.Errorf("读取配置失败: %v", err)
+	}
+	return nil
+}
@@ -3,59 +3,231 @@ package container
 import (
 	"fmt"
 	"os"
-	"os/exec"
+	"runtime"
 	"strings"
 	"syscall"
+
+	"github.com/akm/godocker/rootless"
 )
 
+// 终端模式下传递终端主设备的socket，位于配置管道之后
+const consoleSocketFd = configPipeFd + 1
+
+// initConfig 监控进程通过配置管道传给init进程的容器配置
+type initConfig struct {
+	Args     []string        // 要执行的命令及参数
+	Env      []string        // 命令的环境变量
+	Cwd      string          // 工作目录
+	User     string          // 运行命令的用户
+	Hostname string          // 主机名
+	Rootfs   string          // 容器根文件系统
+	Mounts   []VolumeMapping // 挂载到容器中的主机目录
+	Tmpfs    []TmpfsMount    // 挂载到容器中的tmpfs
+	Devices  []DeviceMapping // 映射到容器中的主机设备
+	Tty      bool            // 是否在容器内创建终端
+	ReadOnly bool            // 只读挂载根文件系统
+	Userns   bool            // 是否在user namespace中运行，此时不能创建设备文件
+
+	SharedNamespaces []string // 与主机、pod或其他容器共享的namespace，如 "pid"、"uts"
+	ShmSource        string   // 绑定挂载到 /dev/shm 的主机目录
+	MaskedPaths      []string // 屏蔽的内核路径，容器内无法读取
+	ReadonlyPaths    []string // 只读的内核路径
+}
+
+// newInitConfig 根据容器信息生成init进程的配置
+func newInitConfig(container *ContainerInfo) *initConfig {
+	config := &container.Config
+	hostname := containerHostname(container)
+
+	return &initConfig{
+		Args:     container.Command,
+		Env:      append(defaultEnv(hostname, config.Tty), config.Env...),
+		Cwd:      config.WorkDir,
+		User:     config.User,
+		Hostname: hostname,
+		Rootfs:   container.Rootfs,
+		Mounts:   config.Volumes,
+		Tmpfs:    config.Tmpfs,
+		Devices:  config.Devices,
+		Tty:      config.Tty,
+		ReadOnly: config.ReadOnly,
+		Userns:   config.usernsEnabled() || rootless.Enabled(),
+
+		SharedNamespaces: config.sharedNamespaces(),
+		ShmSource:        shmSource(container),
+		MaskedPaths:      unmaskPaths(defaultMaskedPaths, config.Unmask),
+		ReadonlyPaths:    unmaskPaths(defaultReadonlyPaths, config.Unmask),
+	}
+}
+
+// containerHostname 返回容器内的主机名，共享主机的UTS namespace时就是主机的主机名，
+// pod的成员使用pod名称
+func containerHostname(container *ContainerInfo) string {
+	if container.Config.sharesHostNamespace("uts") {
+		hostname, _ := os.Hostname()
+		return hostname
+	}
+	if container.Config.Pod != "" {
+		if pod, err := findPod(container.Config.Pod); err == nil {
+			return pod.Name
+		}
+	}
+	if container.Config.Hostname != "" {
+		return container.Config.Hostname
+	}
+	return container.Name
+}
+
 // InitContainer 在容器命名空间中运行的初始化函数
 // 作为容器的1号进程，负责设置容器环境并执行用户命令
 func InitContainer() error {
-	// 获取环境变量中的容器配置
-	rootfs := os.Getenv("CONTAINER_ROOTFS")
-	cmdString := os.Getenv("CONTAINER_CMD")
-	containerName := os.Getenv("CONTAINER_NAME")
+	// cgroup namespace只对调用线程生效，锁定线程保证最后执行exec的是同一个线程
+	runtime.LockOSThread()
+
+	// 等待监控进程准备好容器环境后发来配置
+	var config initConfig
+	if err := readConfigPipe(&config); err != nil {
+		return err
+	}
+
+	if config.Rootfs == "" || len(config.Args) == 0 {
+		return fmt.Errorf("缺少必要的容器配置")
+	}
 
-	if rootfs == "" || cmdString == "" {
-		return fmt.Errorf("缺少必要的容器环境配置")
+	fmt.Printf("初始化容器: %s (rootfs: %s)\n", config.Hostname, config.Rootfs)
+
+	// 监控进程发来配置时已经把init加入了资源限制的cgroup，这时再隔离cgroup
+	if err := enterCgroupNamespace(); err != nil {
+		return fmt.Errorf("创建cgroup namespace失败: %v", err)
 	}
 
-	fmt.Printf("初始化容器: %s (rootfs: %s)\n", containerName, rootfs)
+	// 设置主机名，共享的UTS namespace由主机或pod的infra进程设置主机名
+	if !containsString(config.SharedNamespaces, "uts") {
+		if err := setHostname(config.Hostname); err != nil {
+			return fmt.Errorf("设置主机名失败: %v", err)
+		}
+	}
 
-	// 设置主机名
-	if err := setHostname(containerName); err != nil {
-		return fmt.Errorf("设置主机名失败: %v", err)
+	// 设置私有挂载传播，容器内的挂载不会影响主机
+	if err := prepareRoot(config.Rootfs, config.Mounts); err != nil {
+		return err
 	}
 
 	// 挂载文件系统
-	if err := setupContainerMounts(rootfs); err != nil {
+	if err := setupContainerMounts(config.Rootfs, config.Userns, config.ShmSource); err != nil {
 		return fmt.Errorf("设置容器挂载点失败: %v", err)
 	}
 
+	// 创建映射到容器中的主机设备
+	if err := setupDevices(config.Rootfs, config.Devices, config.Userns); err != nil {
+		return fmt.Errorf("创建设备失败: %v", err)
+	}
+
+	// 挂载数据卷
+	if err := setupVolumes(config.Rootfs, config.Mounts); err != nil {
+		return fmt.Errorf("挂载数据卷失败: %v", err)
+	}
+
+	// 挂载tmpfs
+	if err := setupTmpfs(config.Rootfs, config.Tmpfs); err != nil {
+		return fmt.Errorf("挂载tmpfs失败: %v", err)
+	}
+
 	// 切换根目录
-	if err := syscall.Chroot(rootfs); err != nil {
-		return fmt.Errorf("chroot失败: %v", err)
+	if err := pivotRoot(config.Rootfs); err != nil {
+		return err
 	}
 
-	// 切换工作目录
-	if err := os.Chdir("/"); err != nil {
+	// 屏蔽敏感的内核路径，需要在切换根目录之后进行，这时 /proc 和 /dev/null 都已经是容器自己的
+	if err := maskPaths(config.MaskedPaths); err != nil {
+		return err
+	}
+	if err := readonlyPaths(config.ReadonlyPaths); err != nil {
+		return err
+	}
+
+	// 切换工作目录，不存在时自动创建
+	cwd := config.Cwd
+	if cwd == "" {
+		cwd = "/"
+	}
+	if err := os.MkdirAll(cwd, 0755); err != nil {
+		return fmt.Errorf("创建工作目录失败: %v", err)
+	}
+	if err := os.Chdir(cwd); err != nil {
 		return fmt.Errorf("切换工作目录失败: %v", err)
 	}
 
-	// 解析命令
-	cmdParts := strings.Split(cmdString, " ")
-	if len(cmdParts) == 0 {
-		return fmt.Errorf("无效的容器命令")
+	// 所有挂载完成、工作目录创建之后再把根目录设为只读
+	if config.ReadOnly {
+		if err := remountReadonly("/"); err != nil {
+			return fmt.Errorf("设置根文件系统只读失败: %v", err)
+		}
 	}
 
-	// 查找命令路径
-	cmdPath, err := exec.LookPath(cmdParts[0])
+	// 在容器的 /etc/passwd 中解析用户
+	user, err := lookupUser(config.User)
 	if err != nil {
-		return fmt.Errorf("找不到命令 %s: %v", cmdParts[0], err)
+		return err
+	}
+	env := config.Env
+	if !hasEnv(env, "HOME") {
+		env = append(env, "HOME="+user.Home)
+	}
+
+	// 使用容器内的PATH查找命令
+	cmdPath, err := lookPathIn(config.Args[0], env)
+	if err != nil {
+		return fmt.Errorf("找不到命令 %s: %v", config.Args[0], err)
 	}
 
-	fmt.Printf("在容器中执行命令: %s\n", cmdString)
+	// 终端模式下创建容器的伪终端
+	if config.Tty {
+		if err := setupConsole(consoleSocketFd); err != nil {
+			return fmt.Errorf("创建终端失败: %v", err)
+		}
+	}
+
+	// 切换到指定用户，需要在创建终端之后进行
+	if err := setUser(user); err != nil {
+		return err
+	}
+
+	fmt.Printf("在容器中执行命令: %s\n", strings.Join(config.Args, " "))
 
 	// 执行命令
-	return syscall.Exec(cmdPath, cmdParts, os.Environ())
+	return syscall.Exec(cmdPath, config.Args, env)
+}
+
+// setUser 切换当前进程的用户和用户组
+func setUser(user *ExecUser) error {
+	// rootless模式只映射当前用户时禁止了setgroups，此时进程本来就没有附加组
+	if !setgroupsDenied() {
+		if err := syscall.Setgroups([]int{}); err != nil {
+			return fmt.Errorf("设置附加组失败: %v", err)
+		}
+	}
+	if err := syscall.Setgid(int(user.Gid)); err != nil {
+		return fmt.Errorf("设置用户组失败: %v", err)
+	}
+	if err := syscall.Setuid(int(user.Uid)); err != nil {
+		return fmt.Errorf("设置用户失败: %v", err)
+	}
+	return nil
+}
+
+// setgroupsDenied 判断当前user namespace是否禁止了setgroups
+func setgroupsDenied() bool {
+	data, err := os.ReadFile("/proc/self/setgroups")
+	return err == nil && strings.TrimSpace(string(data)) == "deny"
+}
+
+// hasEnv 判断环境变量列表中是否设置了指定的变量
+func hasEnv(env []string, key string) bool {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			return true
+		}
+	}
+	return false
 }
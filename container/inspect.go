@@ -0,0 +1,46 @@
+package container
+
+import "time"
+
+// ContainerState 容器的运行状态，用于inspect命令展示
+type ContainerState struct {
+	Status     Status    // 容器状态
+	Running    bool      // 是否正在运行
+	Paused     bool      // 是否已暂停
+	Pid        int       // 容器主进程ID，未运行时为0
+	ExitCode   int       // 最近一次退出的状态码
+	OOMKilled  bool      // 是否因内存不足被杀死
+	StartedAt  time.Time // 最近一次启动时间
+	FinishedAt time.Time // 最近一次退出时间
+}
+
+// ContainerInspect inspect命令输出的容器详情
+// 包含容器记录的全部字段，另外把运行状态汇总到 State 中，便于模板中使用 {{.State.Pid}}
+type ContainerInspect struct {
+	*ContainerInfo
+	State   ContainerState // 运行状态
+	LogPath string         // 容器日志文件路径
+}
+
+// InspectContainer 获取容器的详细信息
+func InspectContainer(containerId string) (*ContainerInspect, error) {
+	container, err := findContainer(containerId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ContainerInspect{
+		ContainerInfo: container,
+		State: ContainerState{
+			Status:     container.Status,
+			Running:    container.Status == StatusRunning,
+			Paused:     container.Status == StatusPaused,
+			Pid:        container.Pid,
+			ExitCode:   container.ExitCode,
+			OOMKilled:  container.OOMKilled,
+			StartedAt:  container.StartedAt,
+			FinishedAt: container.FinishedAt,
+		},
+		LogPath: containerLogPath(container.ID),
+	}, nil
+}
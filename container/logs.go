@@ -0,0 +1,232 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LogEntry 容器日志文件中的一行记录
+type LogEntry struct {
+	Stream string    `json:"stream"` // 输出流：stdout 或 stderr
+	Time   time.Time `json:"time"`   // 输出时间
+	Log    string    `json:"log"`    // 输出内容，包含行尾的换行符
+}
+
+// LogOptions 读取容器日志的选项
+type LogOptions struct {
+	Follow     bool      // 是否持续输出新日志
+	Tail       int       // 只输出最后N行，小于0表示全部
+	Since      time.Time // 只输出该时间之后的日志
+	Timestamps bool      // 是否在每行前输出时间戳
+}
+
+const (
+	// 容器日志文件名，位于容器状态目录下
+	logFileName = "container-json.log"
+
+	// 流名称
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+)
+
+// containerLogPath 返回容器日志文件路径
+func containerLogPath(containerId string) string {
+	return filepath.Join(containerDir(containerId), logFileName)
+}
+
+// jsonLogFile 以JSON Lines格式写入容器日志，stdout和stderr共用同一个文件
+type jsonLogFile struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// openJSONLogFile 以追加方式打开容器日志文件
+func openJSONLogFile(containerId string) (*jsonLogFile, error) {
+	file, err := os.OpenFile(containerLogPath(containerId), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("打开容器日志文件失败: %v", err)
+	}
+	return &jsonLogFile{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// writeLine 写入一行日志
+func (l *jsonLogFile) writeLine(stream string, line []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.encoder.Encode(&LogEntry{Stream: stream, Time: time.Now().UTC(), Log: string(line)})
+}
+
+// Close 关闭日志文件
+func (l *jsonLogFile) Close() error {
+	return l.file.Close()
+}
+
+// copyLogStream 读取容器输出并按行写入日志文件
+// 读到的数据同时原样交给 tee（可以为nil），不必等待整行，便于交互式程序及时显示提示符
+func copyLogStream(logFile *jsonLogFile, stream string, src io.Reader, tee func([]byte)) {
+	buf := make([]byte, 32*1024)
+	var partial []byte
+
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if tee != nil {
+				tee(buf[:n])
+			}
+
+			partial = append(partial, buf[:n]...)
+			for {
+				idx := bytes.IndexByte(partial, '\n')
+				if idx < 0 {
+					break
+				}
+				writeLogLine(logFile, stream, partial[:idx+1])
+				partial = partial[idx+1:]
+			}
+		}
+		if err != nil {
+			// 输出结束时写入最后不完整的一行
+			if len(partial) > 0 {
+				writeLogLine(logFile, stream, partial)
+			}
+			return
+		}
+	}
+}
+
+func writeLogLine(logFile *jsonLogFile, stream string, line []byte) {
+	if err := logFile.writeLine(stream, line); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: 写入容器日志失败: %v\n", err)
+	}
+}
+
+// ReadContainerLogs 读取容器日志，按流分别写入 stdout 和 stderr
+func ReadContainerLogs(containerId string, options LogOptions, stdout, stderr io.Writer) error {
+	container, err := findContainer(containerId)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(containerLogPath(container.ID))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("打开容器日志失败: %v", err)
+		}
+		if !options.Follow {
+			return nil
+		}
+		// 容器还没有输出过日志，等待日志文件创建
+		if file, err = waitLogFile(container); err != nil || file == nil {
+			return err
+		}
+	}
+	defer file.Close()
+
+	write := func(entry *LogEntry) {
+		if !options.Since.IsZero() && entry.Time.Before(options.Since) {
+			return
+		}
+		out := stdout
+		if entry.Stream == streamStderr {
+			out = stderr
+		}
+		if options.Timestamps {
+			fmt.Fprintf(out, "%s %s", entry.Time.Format(time.RFC3339Nano), entry.Log)
+		} else {
+			io.WriteString(out, entry.Log)
+		}
+	}
+
+	reader := bufio.NewReader(file)
+
+	// 先输出已有的日志，只保留Since之后的最后Tail行
+	var tail []*LogEntry
+	pending, err := readLogEntries(reader, func(entry *LogEntry) {
+		if !options.Since.IsZero() && entry.Time.Before(options.Since) {
+			return
+		}
+		if options.Tail < 0 {
+			write(entry)
+			return
+		}
+		tail = append(tail, entry)
+		if len(tail) > options.Tail {
+			tail = tail[1:]
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, entry := range tail {
+		write(entry)
+	}
+
+	if !options.Follow {
+		return nil
+	}
+
+	// 持续读取新写入的日志，直到容器退出
+	for {
+		pending, err = readLogEntries(reader, write, pending...)
+		if err != nil {
+			return err
+		}
+
+		latest, err := loadContainerInfo(container.ID)
+		if err != nil || !latest.Status.IsActive() {
+			// 容器已退出，读完剩余的日志后结束
+			_, err = readLogEntries(reader, write, pending...)
+			return err
+		}
+
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// readLogEntries 读取到文件末尾为止的所有完整日志行
+// 返回末尾尚未写完的半行，下次读取时通过 pending 传入
+func readLogEntries(reader *bufio.Reader, handle func(*LogEntry), pending ...byte) ([]byte, error) {
+	for {
+		chunk, err := reader.ReadBytes('\n')
+		pending = append(pending, chunk...)
+		if err == io.EOF {
+			return pending, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取容器日志失败: %v", err)
+		}
+
+		var entry LogEntry
+		if err := json.Unmarshal(pending, &entry); err == nil {
+			handle(&entry)
+		}
+		pending = nil
+	}
+}
+
+// waitLogFile 等待容器日志文件创建，容器退出时返回nil
+func waitLogFile(container *ContainerInfo) (*os.File, error) {
+	for {
+		file, err := os.Open(containerLogPath(container.ID))
+		if err == nil {
+			return file, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("打开容器日志失败: %v", err)
+		}
+
+		latest, err := loadContainerInfo(container.ID)
+		if err != nil || !latest.Status.IsActive() {
+			return nil, err
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
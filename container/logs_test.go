@@ -0,0 +1,134 @@
+package container
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var logBaseTime = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func logTime(seconds float64) time.Time {
+	return logBaseTime.Add(time.Duration(seconds * float64(time.Second)))
+}
+
+// setupTestContainerRoot 把容器数据目录切换到临时目录，测试结束后恢复
+func setupTestContainerRoot(t *testing.T) {
+	t.Helper()
+	saved := DefaultContainerRoot
+	DefaultContainerRoot = t.TempDir()
+	t.Cleanup(func() { DefaultContainerRoot = saved })
+}
+
+// writeTestLog 创建一个已退出的容器，日志文件内容为 entries 后接 extra
+func writeTestLog(t *testing.T, id string, entries []LogEntry, extra string) {
+	t.Helper()
+	container := &ContainerInfo{ID: id, Name: id, Status: StatusExited}
+	if err := saveContainerInfo(container); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for i := range entries {
+		if err := encoder.Encode(&entries[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf.WriteString(extra)
+	if err := os.WriteFile(containerLogPath(id), buf.Bytes(), 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadContainerLogs(t *testing.T) {
+	setupTestContainerRoot(t)
+
+	entries := []LogEntry{
+		{Stream: streamStdout, Time: logTime(0), Log: "a\n"},
+		{Stream: streamStderr, Time: logTime(1), Log: "b\n"},
+		{Stream: streamStdout, Time: logTime(2), Log: "c\n"},
+		{Stream: streamStdout, Time: logTime(3), Log: "d\n"},
+		{Stream: streamStderr, Time: logTime(4), Log: "e\n"},
+		// stdout和stderr由不同的goroutine写入，时间戳不一定递增
+		{Stream: streamStderr, Time: logTime(0.5), Log: "f\n"},
+	}
+	// 格式错误的行被跳过，最后没有换行的半行还没有写完，也不输出
+	writeTestLog(t, "logs", entries, "not json\n{\"stream\":\"stdout\",\"log\":\"partial")
+
+	tests := []struct {
+		name       string
+		options    LogOptions
+		wantStdout string
+		wantStderr string
+	}{
+		{"全部", LogOptions{Tail: -1}, "a\nc\nd\n", "b\ne\nf\n"},
+		{"最后2行", LogOptions{Tail: 2}, "", "e\nf\n"},
+		{"Tail为0", LogOptions{Tail: 0}, "", ""},
+		{"Tail超过行数", LogOptions{Tail: 100}, "a\nc\nd\n", "b\ne\nf\n"},
+		{"Since", LogOptions{Tail: -1, Since: logTime(2)}, "c\nd\n", "e\n"},
+		{"Since在两行之间", LogOptions{Tail: -1, Since: logTime(1.5)}, "c\nd\n", "e\n"},
+		{"Since在所有日志之后", LogOptions{Tail: -1, Since: logTime(10)}, "", ""},
+		{"Since早于所有日志", LogOptions{Tail: -1, Since: logTime(-1)}, "a\nc\nd\n", "b\ne\nf\n"},
+		// 先按Since过滤再取最后Tail行，时间较早的f不能占用Tail的名额
+		{"Since和Tail", LogOptions{Tail: 2, Since: logTime(2)}, "d\n", "e\n"},
+		{"Since和Tail超过行数", LogOptions{Tail: 10, Since: logTime(3)}, "d\n", "e\n"},
+		{"Since和Tail为0", LogOptions{Tail: 0, Since: logTime(0)}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if err := ReadContainerLogs("logs", tt.options, &stdout, &stderr); err != nil {
+				t.Fatalf("ReadContainerLogs() error = %v", err)
+			}
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", stdout.String(), tt.wantStdout)
+			}
+			if stderr.String() != tt.wantStderr {
+				t.Errorf("stderr = %q, want %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestReadContainerLogsTimestamps(t *testing.T) {
+	setupTestContainerRoot(t)
+	writeTestLog(t, "ts", []LogEntry{
+		{Stream: streamStdout, Time: logTime(1.25), Log: "hello\n"},
+	}, "")
+
+	var stdout, stderr bytes.Buffer
+	if err := ReadContainerLogs("ts", LogOptions{Tail: -1, Timestamps: true}, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	want := "2026-01-01T00:00:01.25Z hello\n"
+	if stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+}
+
+func TestReadContainerLogsNoLogFile(t *testing.T) {
+	setupTestContainerRoot(t)
+	writeTestLog(t, "empty", nil, "")
+	if err := os.Remove(filepath.Join(containerDir("empty"), logFileName)); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := ReadContainerLogs("empty", LogOptions{Tail: -1}, &stdout, &stderr); err != nil {
+		t.Fatalf("ReadContainerLogs() error = %v", err)
+	}
+	if stdout.Len() != 0 || stderr.Len() != 0 {
+		t.Errorf("没有日志文件时不应有输出: %q %q", stdout.String(), stderr.String())
+	}
+
+	err := ReadContainerLogs("missing", LogOptions{Tail: -1}, &stdout, &stderr)
+	if !errors.Is(err, ErrContainerNotFound) {
+		t.Errorf("ReadContainerLogs() error = %v, want %v", err, ErrContainerNotFound)
+	}
+}
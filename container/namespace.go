@@ -0,0 +1,200 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/akm/godocker/network"
+)
+
+const (
+	// NamespaceHost 与主机共享namespace，用于 --pid、--ipc 和 --uts
+	NamespaceHost = "host"
+	// NamespaceContainerPrefix 加入其他容器的namespace，如 "container:web"，用于 -net、--pid 和 --ipc
+	NamespaceContainerPrefix = "container:"
+)
+
+// ErrNamespaceInUse 容器的namespace正在被其他容器使用
+var ErrNamespaceInUse = errors.New("容器的namespace正在被其他容器使用")
+
+// 可以与主机、pod或其他容器共享的namespace
+var configurableNamespaces = []string{"pid", "ipc", "uts", "net"}
+
+// resolveNamespaceModes 检查 --pid、--ipc、--uts 和 --pod 的取值，
+// 并把要加入的容器和pod解析为完整的ID，改名后仍然可以找到
+func resolveNamespaceModes(config *Config) error {
+	// pod的成员使用pod的网络、ipc和uts namespace以及pod的主机名
+	if config.Pod != "" {
+		if config.Network != "" || config.IpcMode != "" || config.UtsMode != "" || config.Hostname != "" {
+			return fmt.Errorf("--pod 不能与 -net、--ipc、--uts 或 --hostname 同时使用")
+		}
+		pod, err := findPod(config.Pod)
+		if err != nil {
+			return err
+		}
+		config.Pod = pod.ID
+	}
+
+	modes := []struct {
+		name     string
+		mode     *string
+		joinable bool
+	}{
+		{"pid", &config.PidMode, true},
+		{"ipc", &config.IpcMode, true},
+		{"uts", &config.UtsMode, false},
+		{"net", &config.Network, true},
+	}
+	for _, m := range modes {
+		if !strings.HasPrefix(*m.mode, NamespaceContainerPrefix) {
+			// 网络模式由network包检查
+			if m.name != "net" && *m.mode != "" && *m.mode != NamespaceHost {
+				return fmt.Errorf("不支持的 --%s 取值: %s", m.name, *m.mode)
+			}
+			continue
+		}
+		if !m.joinable {
+			return fmt.Errorf("--%s 不支持加入其他容器: %s", m.name, *m.mode)
+		}
+
+		target, err := findContainer(strings.TrimPrefix(*m.mode, NamespaceContainerPrefix))
+		if err != nil {
+			return fmt.Errorf("--%s: %w", m.name, err)
+		}
+		*m.mode = NamespaceContainerPrefix + target.ID
+	}
+
+	// 共享主机的UTS namespace时修改主机名会改掉主机自己的主机名
+	if config.UtsMode == NamespaceHost && config.Hostname != "" {
+		return fmt.Errorf("--hostname 不能与 --uts=host 同时使用")
+	}
+
+	// 共享ipc namespace时使用主机、pod或目标容器的 /dev/shm，指定的大小不会生效
+	if config.ShmSize > 0 && !config.isolatesNamespace("ipc") {
+		return fmt.Errorf("--shm-size 不能与共享的ipc namespace (--ipc 或 --pod) 同时使用")
+	}
+	return nil
+}
+
+// namespaceMode 返回容器使用指定namespace的方式，为空时创建新的namespace
+func (c *Config) namespaceMode(ns string) string {
+	if c.Pod != "" && containsString(podNamespaces, ns) {
+		return podNamespacePrefix + c.Pod
+	}
+
+	switch ns {
+	case "pid":
+		return c.PidMode
+	case "ipc":
+		return c.IpcMode
+	case "uts":
+		return c.UtsMode
+	case "net":
+		// bridge和none网络都在新的network namespace中
+		if c.Network == network.HostMode || strings.HasPrefix(c.Network, NamespaceContainerPrefix) {
+			return c.Network
+		}
+	}
+	return ""
+}
+
+// isolatesNamespace 判断容器是否需要创建新的namespace
+func (c *Config) isolatesNamespace(ns string) bool {
+	return c.namespaceMode(ns) == ""
+}
+
+// sharedNamespaces 返回与主机、pod或其他容器共享的namespace
+func (c *Config) sharedNamespaces() []string {
+	var namespaces []string
+	for _, ns := range configurableNamespaces {
+		if !c.isolatesNamespace(ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// sharesHostNamespace 判断容器是否与主机共享指定的namespace
+func (c *Config) sharesHostNamespace(ns string) bool {
+	return c.namespaceMode(ns) == NamespaceHost
+}
+
+// joinedContainers 返回要加入其他容器的namespace，键为namespace，值为目标容器ID
+func (c *Config) joinedContainers() map[string]string {
+	joined := map[string]string{}
+	for _, ns := range configurableNamespaces {
+		if mode := c.namespaceMode(ns); strings.HasPrefix(mode, NamespaceContainerPrefix) {
+			joined[ns] = strings.TrimPrefix(mode, NamespaceContainerPrefix)
+		}
+	}
+	return joined
+}
+
+// joinedNamespacePaths 返回要加入的namespace文件路径，目标容器或pod必须正在运行
+func joinedNamespacePaths(config *Config) (map[string]string, error) {
+	paths := map[string]string{}
+	if config.Pod != "" {
+		pod, err := findPod(config.Pod)
+		if err != nil {
+			return nil, err
+		}
+		if pod.Status != StatusRunning {
+			return nil, fmt.Errorf("pod %s 没有运行", pod.Name)
+		}
+		for _, ns := range podNamespaces {
+			paths[ns] = filepath.Join("/proc", strconv.Itoa(pod.InfraPid), "ns", ns)
+		}
+	}
+	for ns, id := range config.joinedContainers() {
+		target, err := findContainer(id)
+		if err != nil {
+			return nil, err
+		}
+		if !target.Status.IsActive() {
+			return nil, fmt.Errorf("%w: %s，无法加入它的%s namespace", ErrContainerNotRunning, target.Name, ns)
+		}
+		paths[ns] = filepath.Join("/proc", strconv.Itoa(target.Pid), "ns", ns)
+	}
+	return paths, nil
+}
+
+// namespaceUsers 返回加入了指定容器namespace的其他容器名称（包括已停止的容器）
+func namespaceUsers(containerId string) ([]string, error) {
+	containers, err := loadAllContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	var users []string
+	for _, c := range containers {
+		for _, id := range c.Config.joinedContainers() {
+			if id == containerId {
+				users = append(users, c.Name)
+				break
+			}
+		}
+	}
+	return users, nil
+}
+
+// namespaceSupported 判断内核是否支持指定的namespace
+// cgroup namespace 从 4.6 开始支持，time namespace 从 5.6 开始支持
+func namespaceSupported(ns string) bool {
+	_, err := os.Stat("/proc/self/ns/" + ns)
+	return err == nil
+}
+
+// supportedNamespaces 去掉内核不支持的namespace
+func supportedNamespaces(namespaces []string) []string {
+	var result []string
+	for _, ns := range namespaces {
+		if namespaceSupported(ns) {
+			result = append(result, ns)
+		}
+	}
+	return result
+}
@@ -0,0 +1,27 @@
+// Package nsenter 在Go运行时启动之前加入其他进程的namespace
+//
+// 加入mount namespace要求调用进程是单线程的，而Go程序启动后总是多线程，
+// 因此这里借助cgo的constructor在main函数之前完成setns。
+// 只需要导入该包，并在启动godocker子进程时通过环境变量指定目标进程：
+//
+//	_GODOCKER_NSENTER_PID   目标进程在主机上的PID
+//	_GODOCKER_NSENTER_NS    要加入的namespace列表，逗号分隔，如 "ipc,uts,net,pid,mnt"
+//	_GODOCKER_NSENTER_ROOT  非空时在加入namespace后chroot到目标进程的根目录
+//	_GODOCKER_NSENTER_CGROUPS  要加入的cgroup的 cgroup.procs 文件，逗号分隔
+//
+// cgroup在加入namespace之前加入，这时进程还是单线程，之后fork出的子进程也在这些cgroup中。
+//
+// 注意Go运行时的环境变量在constructor执行前就已确定，
+// 子进程需要显式构造环境变量，避免把这些变量继续传递下去。
+package nsenter
+
+const (
+	// EnvPid 目标进程PID的环境变量名
+	EnvPid = "_GODOCKER_NSENTER_PID"
+	// EnvNamespaces 要加入的namespace列表的环境变量名
+	EnvNamespaces = "_GODOCKER_NSENTER_NS"
+	// EnvRoot 是否chroot到目标进程根目录的环境变量名
+	EnvRoot = "_GODOCKER_NSENTER_ROOT"
+	// EnvCgroups 要加入的cgroup列表的环境变量名
+	EnvCgroups = "_GODOCKER_NSENTER_CGROUPS"
+)
@@ -0,0 +1,169 @@
+//go:build linux && cgo
+// +build linux,cgo
+
+package nsenter
+
+/*
+#cgo CFLAGS: -Wall
+#define _GNU_SOURCE
+#include <errno.h>
+#include <fcntl.h>
+#include <sched.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <signal.h>
+#include <sys/stat.h>
+#include <sys/wait.h>
+#include <unistd.h>
+
+#define MAX_NAMESPACES 16
+
+static void nsenter_fail(const char *msg, const char *arg) {
+	fprintf(stderr, "nsenter: %s %s: %s\n", msg, arg, strerror(errno));
+	exit(1);
+}
+
+// 当前进程已经在目标namespace中时跳过，重复加入同一个user namespace会返回EINVAL
+static int same_namespace(int fd, const char *ns) {
+	char path[64];
+	struct stat self, target;
+
+	snprintf(path, sizeof(path), "/proc/self/ns/%s", ns);
+	if (stat(path, &self) < 0 || fstat(fd, &target) < 0) {
+		return 0;
+	}
+	return self.st_dev == target.st_dev && self.st_ino == target.st_ino;
+}
+
+// 把当前进程写入各个cgroup的 cgroup.procs，需要在加入mount namespace之前进行，
+// 之后主机的cgroup文件系统可能不再可见
+static void join_cgroups(const char *list) {
+	char paths[4096], pidstr[16];
+	char *saveptr = NULL, *tok;
+	int fd, len;
+
+	snprintf(paths, sizeof(paths), "%s", list);
+	len = snprintf(pidstr, sizeof(pidstr), "%d", getpid());
+	for (tok = strtok_r(paths, ",", &saveptr); tok != NULL; tok = strtok_r(NULL, ",", &saveptr)) {
+		fd = open(tok, O_WRONLY | O_CLOEXEC);
+		if (fd < 0) {
+			nsenter_fail("打开cgroup失败", tok);
+		}
+		if (write(fd, pidstr, len) != len) {
+			nsenter_fail("加入cgroup失败", tok);
+		}
+		close(fd);
+	}
+}
+
+static pid_t child_pid = -1;
+
+static void forward_signal(int sig) {
+	if (child_pid > 0) {
+		kill(child_pid, sig);
+	}
+}
+
+// 加入pid namespace之后当前进程不能再创建线程（clone会返回EINVAL），
+// 而且只有子进程才会真正进入新的pid namespace，因此需要fork一次。
+// 父进程留在原地等待子进程退出并转发信号，子进程继续执行Go运行时。
+static void fork_into_pidns(void) {
+	int sigs[] = {SIGHUP, SIGINT, SIGQUIT, SIGTERM, SIGUSR1, SIGUSR2, SIGWINCH};
+	int status, i;
+
+	child_pid = fork();
+	if (child_pid < 0) {
+		nsenter_fail("fork失败", "");
+	}
+	if (child_pid == 0) {
+		return;
+	}
+
+	for (i = 0; i < (int)(sizeof(sigs) / sizeof(sigs[0])); i++) {
+		signal(sigs[i], forward_signal);
+	}
+
+	while (waitpid(child_pid, &status, 0) < 0) {
+		if (errno != EINTR) {
+			nsenter_fail("等待子进程失败", "");
+		}
+	}
+
+	if (WIFSIGNALED(status)) {
+		exit(128 + WTERMSIG(status));
+	}
+	exit(WEXITSTATUS(status));
+}
+
+__attribute__((constructor)) static void nsenter(void) {
+	const char *pid = getenv("_GODOCKER_NSENTER_PID");
+	const char *list = getenv("_GODOCKER_NSENTER_NS");
+	const char *root = getenv("_GODOCKER_NSENTER_ROOT");
+	const char *cgroups = getenv("_GODOCKER_NSENTER_CGROUPS");
+	char names[256], path[64];
+	char *ns[MAX_NAMESPACES];
+	int fds[MAX_NAMESPACES];
+	int count = 0, rootfd = -1, joined_pidns = 0, i;
+	char *saveptr = NULL, *tok;
+
+	if (pid == NULL || *pid == '\0') {
+		return;
+	}
+
+	if (cgroups != NULL && *cgroups != '\0') {
+		join_cgroups(cgroups);
+	}
+
+	// 加入mount namespace之后/proc可能变成容器的proc，所以先打开所有需要的文件
+	if (list != NULL) {
+		snprintf(names, sizeof(names), "%s", list);
+		for (tok = strtok_r(names, ",", &saveptr); tok != NULL && count < MAX_NAMESPACES;
+		     tok = strtok_r(NULL, ",", &saveptr)) {
+			snprintf(path, sizeof(path), "/proc/%s/ns/%s", pid, tok);
+			fds[count] = open(path, O_RDONLY | O_CLOEXEC);
+			if (fds[count] < 0) {
+				nsenter_fail("打开namespace失败", path);
+			}
+			ns[count++] = tok;
+		}
+	}
+
+	if (root != NULL && *root != '\0') {
+		snprintf(path, sizeof(path), "/proc/%s/root", pid);
+		rootfd = open(path, O_RDONLY | O_DIRECTORY | O_CLOEXEC);
+		if (rootfd < 0) {
+			nsenter_fail("打开根目录失败", path);
+		}
+	}
+
+	for (i = 0; i < count; i++) {
+		if (!same_namespace(fds[i], ns[i])) {
+			if (setns(fds[i], 0) < 0) {
+				nsenter_fail("加入namespace失败", ns[i]);
+			}
+			if (strcmp(ns[i], "pid") == 0) {
+				joined_pidns = 1;
+			}
+		}
+		close(fds[i]);
+	}
+
+	if (rootfd >= 0) {
+		if (fchdir(rootfd) < 0 || chroot(".") < 0 || chdir("/") < 0) {
+			nsenter_fail("切换根目录失败", pid);
+		}
+		close(rootfd);
+	}
+
+	if (joined_pidns) {
+		fork_into_pidns();
+	}
+}
+*/
+import "C"
+
+// Supported 当前构建是否支持在启动时加入namespace
+func Supported() bool {
+	return true
+}
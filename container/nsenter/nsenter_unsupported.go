@@ -0,0 +1,10 @@
+//go:build !linux || !cgo
+// +build !linux !cgo
+
+package nsenter
+
+// Supported 当前构建是否支持在启动时加入namespace
+// 非Linux平台或未启用cgo时无法在Go运行时启动前调用setns
+func Supported() bool {
+	return false
+}
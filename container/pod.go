@@ -0,0 +1,529 @@
+package container
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/akm/godocker/config"
+	"github.com/akm/godocker/network"
+)
+
+// PodConfig pod配置
+type PodConfig struct {
+	Name    string                // pod名称，同时作为成员容器的主机名
+	Network string                // 网络模式：bridge, host, none
+	Ports   []network.PortMapping // 发布到主机上的端口，由pod的IP接收
+}
+
+// PodInfo pod信息
+// pod由一个infra进程持有network、ipc和uts namespace，成员容器启动时加入这些namespace，
+// 因此成员之间可以通过localhost和共享内存通信，并且使用同一个主机名和IP
+type PodInfo struct {
+	ID         string                 // pod ID
+	Name       string                 // pod名称
+	Status     Status                 // pod状态，与infra进程的状态一致
+	InfraPid   int                    // infra进程ID
+	InfraStart uint64                 // infra进程启动时间，用于识别PID复用
+	CreateTime time.Time              // 创建时间
+	Config     PodConfig              // pod配置
+	Network    *network.NetworkConfig // pod网络配置
+	Containers []string               // 成员容器ID，读取时根据容器配置生成
+}
+
+// pod数据根目录，每个pod一个以ID命名的子目录
+var DefaultPodRoot = filepath.Join(config.DataRoot(), "pods")
+
+const (
+	podInfoFileName    = "pod.json"
+	podInfraLogName    = "infra.log"
+	podLockFileName    = "pod.lock"
+	podShmDirName      = "shm"
+	podInfraArg        = "podinfra"
+	podNamespacePrefix = "pod:"
+)
+
+// pod的成员容器共享的namespace
+var podNamespaces = []string{"net", "ipc", "uts"}
+
+// pod相关的错误，调用方可以使用 errors.Is 判断
+var (
+	ErrPodNotFound = errors.New("找不到pod")
+	ErrPodRunning  = errors.New("pod正在运行")
+)
+
+// CreatePod 创建pod并预留网络资源，pod在第一次启动时才创建infra进程
+func CreatePod(podConfig *PodConfig) (string, error) {
+	podId := generateContainerId()
+	if podConfig.Name == "" {
+		podConfig.Name = podId[:12]
+	}
+	pods, err := loadAllPods()
+	if err != nil {
+		return "", err
+	}
+	for _, p := range pods {
+		if p.Name == podConfig.Name {
+			return "", fmt.Errorf("已存在同名pod: %s", podConfig.Name)
+		}
+	}
+
+	if podConfig.Network == "" {
+		podConfig.Network = network.BridgeMode
+	}
+	switch podConfig.Network {
+	case network.BridgeMode, network.HostMode, network.NoneMode:
+	default:
+		return "", fmt.Errorf("pod不支持的网络模式: %s", podConfig.Network)
+	}
+	if len(podConfig.Ports) > 0 && podConfig.Network != network.BridgeMode {
+		return "", fmt.Errorf("只有bridge网络的pod可以发布端口")
+	}
+
+	pod := &PodInfo{
+		ID:         podId,
+		Name:       podConfig.Name,
+		Status:     StatusCreated,
+		CreateTime: time.Now(),
+		Config:     *podConfig,
+	}
+
+	netConfig, err := network.ReserveNetwork(podConfig.Network, podId)
+	if err != nil {
+		return "", fmt.Errorf("预留pod网络失败: %v", err)
+	}
+	pod.Network = netConfig
+
+	if err := savePodInfo(pod); err != nil {
+		network.ReleaseNetwork(pod.Network, podId)
+		os.RemoveAll(podDir(podId))
+		return "", err
+	}
+	return podId, nil
+}
+
+// StartPod 启动pod的infra进程和所有成员容器
+func StartPod(ref string) error {
+	pod, err := findPod(ref)
+	if err != nil {
+		return err
+	}
+	if err := startPodInfra(pod); err != nil {
+		return err
+	}
+
+	members, err := podMembers(pod.ID)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, c := range members {
+		if c.Status.IsActive() {
+			continue
+		}
+		if err := StartContainer(c.ID, false); err != nil {
+			fmt.Printf("启动容器 %s 失败: %v\n", c.Name, err)
+			failed = append(failed, c.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("部分容器启动失败: %v", failed)
+	}
+	return nil
+}
+
+// StopPod 停止所有成员容器和pod的infra进程
+func StopPod(ref string) error {
+	pod, err := findPod(ref)
+	if err != nil {
+		return err
+	}
+
+	members, err := podMembers(pod.ID)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, c := range members {
+		if !c.Status.IsActive() {
+			continue
+		}
+		if err := StopContainer(c.ID); err != nil {
+			fmt.Printf("停止容器 %s 失败: %v\n", c.Name, err)
+			failed = append(failed, c.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("部分容器停止失败: %v", failed)
+	}
+
+	return stopPodInfra(pod)
+}
+
+// RemovePod 删除pod及其所有成员容器
+// pod或成员容器仍在运行时返回 ErrPodRunning，force为true时先停止
+func RemovePod(ref string, force bool) error {
+	pod, err := findPod(ref)
+	if err != nil {
+		return err
+	}
+
+	members, err := podMembers(pod.ID)
+	if err != nil {
+		return err
+	}
+	running := pod.Status == StatusRunning
+	for _, c := range members {
+		running = running || c.Status.IsActive()
+	}
+	if running {
+		if !force {
+			return fmt.Errorf("%w: %s，请先停止pod或使用 -f 强制删除", ErrPodRunning, pod.Name)
+		}
+		if err := StopPod(pod.ID); err != nil {
+			return fmt.Errorf("停止pod失败: %v", err)
+		}
+	}
+
+	// 成员之间可能通过 container:<ID> 共享namespace，被加入的容器要等加入它的容器删除后才能删除
+	for len(members) > 0 {
+		var remaining []*ContainerInfo
+		var lastErr error
+		for _, c := range members {
+			if err := RemoveContainer(c.ID, true); err != nil {
+				if !errors.Is(err, ErrNamespaceInUse) {
+					return fmt.Errorf("删除容器 %s 失败: %v", c.Name, err)
+				}
+				remaining = append(remaining, c)
+				lastErr = err
+			}
+		}
+		if len(remaining) == len(members) {
+			return lastErr
+		}
+		members = remaining
+	}
+
+	if err := network.ReleaseNetwork(pod.Network, pod.ID); err != nil {
+		fmt.Printf("警告: 释放pod网络失败: %v\n", err)
+	}
+	unmountShm(podShmPath(pod.ID))
+	return os.RemoveAll(podDir(pod.ID))
+}
+
+// ListPods 列出所有pod，最新创建的在前
+func ListPods() ([]*PodInfo, error) {
+	pods, err := loadAllPods()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].CreateTime.After(pods[j].CreateTime)
+	})
+	return pods, nil
+}
+
+// InspectPod 获取pod的详细信息
+func InspectPod(ref string) (*PodInfo, error) {
+	return findPod(ref)
+}
+
+// ensurePodRunning 启动成员容器之前确保pod的infra进程正在运行
+func ensurePodRunning(podId string) error {
+	pod, err := findPod(podId)
+	if err != nil {
+		return err
+	}
+	return startPodInfra(pod)
+}
+
+// startPodInfra 启动pod的infra进程并配置网络，已经在运行时不做任何操作
+// 网络配置完成后才把pod记录为运行中，成员容器不会加入没有网络的namespace
+func startPodInfra(pod *PodInfo) error {
+	unlock, err := lockPod(pod.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// 其他godocker进程可能已经启动了infra进程，加锁后重新读取状态
+	pod, err = loadPodInfo(pod.ID)
+	if err != nil {
+		return fmt.Errorf("读取pod信息失败: %v", err)
+	}
+	if pod.Status == StatusRunning {
+		return nil
+	}
+
+	// 成员容器加入pod的IPC namespace时绑定挂载这个目录作为 /dev/shm
+	if err := mountShm(podShmPath(pod.ID), 0); err != nil {
+		return err
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(podDir(pod.ID), podInfraLogName),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		unmountShm(podShmPath(pod.ID))
+		return fmt.Errorf("创建infra进程日志失败: %v", err)
+	}
+	defer logFile.Close()
+
+	// infra进程在新的namespace中运行，不随当前进程退出
+	cmd := exec.Command("/proc/self/exe", podInfraArg, pod.Name)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	setPodNamespaceFlags(cmd.SysProcAttr, pod.Config.Network)
+	cmd.Env = []string{}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Start(); err != nil {
+		unmountShm(podShmPath(pod.ID))
+		return fmt.Errorf("启动pod infra进程失败: %v", err)
+	}
+
+	// 启动失败时停止infra进程，pod保持原来的状态
+	fail := func(err error) error {
+		cmd.Process.Kill()
+		cmd.Wait()
+		network.UnpublishPorts(pod.Network, pod.Config.Ports)
+		unmountShm(podShmPath(pod.ID))
+		return err
+	}
+
+	if pod.Network != nil && pod.Network.Mode == network.BridgeMode {
+		if err := network.SetupNetwork(pod.Network, pod.ID, cmd.Process.Pid); err != nil {
+			return fail(fmt.Errorf("配置pod网络失败: %v", err))
+		}
+		// 清除infra进程异常退出时残留的规则
+		network.UnpublishPorts(pod.Network, pod.Config.Ports)
+		if err := network.PublishPorts(pod.Network, pod.Config.Ports); err != nil {
+			return fail(err)
+		}
+	}
+
+	pod.InfraPid = cmd.Process.Pid
+	pod.InfraStart = processStartTime(pod.InfraPid)
+	pod.Status = StatusRunning
+	if err := savePodInfo(pod); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+// stopPodInfra 停止pod的infra进程，删除发布的端口
+func stopPodInfra(pod *PodInfo) error {
+	unlock, err := lockPod(pod.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	pod, err = loadPodInfo(pod.ID)
+	if err != nil {
+		return fmt.Errorf("读取pod信息失败: %v", err)
+	}
+	if pod.Status == StatusRunning {
+		syscall.Kill(pod.InfraPid, syscall.SIGTERM)
+		deadline := time.Now().Add(DefaultStopTimeout)
+		for processAlive(pod.InfraPid, pod.InfraStart) {
+			if time.Now().After(deadline) {
+				syscall.Kill(pod.InfraPid, syscall.SIGKILL)
+				deadline = time.Now().Add(DefaultStopTimeout)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	network.UnpublishPorts(pod.Network, pod.Config.Ports)
+	unmountShm(podShmPath(pod.ID))
+
+	if pod.Status != StatusRunning {
+		return nil
+	}
+	pod.Status = StatusExited
+	pod.InfraPid = 0
+	pod.InfraStart = 0
+	return savePodInfo(pod)
+}
+
+// lockPod 对pod加文件锁，防止多个godocker进程同时启动或停止同一个pod的infra进程
+// 返回的函数用于释放锁
+func lockPod(podId string) (func(), error) {
+	return lockFile(filepath.Join(podDir(podId), podLockFileName))
+}
+
+// lockFile 以排他方式锁定文件，返回解锁函数
+func lockFile(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("打开锁文件失败: %v", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("锁定 %s 失败: %v", path, err)
+	}
+	// 关闭文件时锁自动释放
+	return func() { file.Close() }, nil
+}
+
+// RunPodInfra pod的infra进程，设置pod的主机名后一直等待，直到收到停止信号
+// 它只负责让pod的namespace在没有成员容器运行时也继续存在
+func RunPodInfra(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("缺少pod名称")
+	}
+	if err := setHostname(args[0]); err != nil {
+		return fmt.Errorf("设置主机名失败: %v", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	signal.Ignore(syscall.SIGHUP)
+	<-signals
+	return nil
+}
+
+// podMembers 返回pod的所有成员容器
+func podMembers(podId string) ([]*ContainerInfo, error) {
+	containers, err := loadAllContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	var members []*ContainerInfo
+	for _, c := range containers {
+		if c.Config.Pod == podId {
+			members = append(members, c)
+		}
+	}
+	return members, nil
+}
+
+// podDir 返回pod的状态目录
+func podDir(podId string) string {
+	return filepath.Join(DefaultPodRoot, podId)
+}
+
+// podShmPath 返回pod的 /dev/shm 在主机上的挂载点
+func podShmPath(podId string) string {
+	return filepath.Join(podDir(podId), podShmDirName)
+}
+
+// savePodInfo 保存pod信息，先写临时文件再重命名
+func savePodInfo(pod *PodInfo) error {
+	dir := podDir(pod.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建pod目录失败: %v", err)
+	}
+
+	data, err := json.MarshalIndent(pod, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化pod信息失败: %v", err)
+	}
+
+	path := filepath.Join(dir, podInfoFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("保存pod信息失败: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("保存pod信息失败: %v", err)
+	}
+	return nil
+}
+
+// loadPodInfo 读取pod信息，修正infra进程已退出的状态并填充成员容器
+func loadPodInfo(podId string) (*PodInfo, error) {
+	data, err := os.ReadFile(filepath.Join(podDir(podId), podInfoFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var pod PodInfo
+	if err := json.Unmarshal(data, &pod); err != nil {
+		return nil, fmt.Errorf("解析pod信息失败: %v", err)
+	}
+
+	if pod.Status == StatusRunning && !processAlive(pod.InfraPid, pod.InfraStart) {
+		pod.Status = StatusExited
+		if err := savePodInfo(&pod); err != nil {
+			fmt.Printf("警告: 更新pod %s 状态失败: %v\n", pod.ID, err)
+		}
+	}
+
+	members, err := podMembers(pod.ID)
+	if err != nil {
+		return nil, err
+	}
+	pod.Containers = nil
+	for _, c := range members {
+		pod.Containers = append(pod.Containers, c.ID)
+	}
+	return &pod, nil
+}
+
+// loadAllPods 读取所有pod
+func loadAllPods() ([]*PodInfo, error) {
+	entries, err := os.ReadDir(DefaultPodRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("读取pod目录失败: %v", err)
+	}
+
+	var pods []*PodInfo
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		pod, err := loadPodInfo(entry.Name())
+		if err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Printf("警告: 读取pod %s 失败: %v\n", entry.Name(), err)
+			}
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// findPod 根据pod ID、ID前缀或名称查找pod
+func findPod(ref string) (*PodInfo, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("pod ID不能为空")
+	}
+
+	pods, err := loadAllPods()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []*PodInfo
+	for _, p := range pods {
+		if p.ID == ref || p.Name == ref {
+			return p, nil
+		}
+		if strings.HasPrefix(p.ID, ref) {
+			matched = append(matched, p)
+		}
+	}
+
+	switch len(matched) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", ErrPodNotFound, ref)
+	case 1:
+		return matched[0], nil
+	default:
+		return nil, fmt.Errorf("pod ID前缀 %s 匹配到多个pod", ref)
+	}
+}
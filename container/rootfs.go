@@ -0,0 +1,190 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/akm/godocker/image"
+	"github.com/akm/godocker/storage"
+)
+
+// prepareRootfs 为容器准备写时复制的根文件系统
+// 在镜像最上层之上创建容器自己的可写层，由镜像所用的存储驱动挂载出完整的根目录
+func prepareRootfs(container *ContainerInfo) error {
+	img, err := image.GetImage(container.Image)
+	if err != nil {
+		return err
+	}
+
+	driver, err := storage.GetDriver(img.StorageDriver)
+	if err != nil {
+		return err
+	}
+	container.StorageDriver = driver.Name()
+	parent := img.Layers[len(img.Layers)-1]
+
+	// 使用user namespace时，镜像中文件的属主要按映射转换为主机ID。
+	// 优先以idmapped方式挂载镜像层；不支持时使用按映射修改过属主的共享镜像副本
+	if container.Config.usernsEnabled() {
+		if _, ok := driver.(storage.IDMapper); ok {
+			err := prepareIDMappedRootfs(container, driver, parent)
+			if err == nil {
+				return nil
+			}
+			if !errors.Is(err, storage.ErrIDMapUnsupported) {
+				return err
+			}
+			fmt.Printf("存储驱动不支持idmapped mount，使用修改属主后的镜像副本: %v\n", err)
+		}
+
+		parent, err = mappedImageLayer(driver, parent, container.Config.UidMap, container.Config.GidMap)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := driver.Create(container.ID, parent); err != nil {
+		return fmt.Errorf("创建容器层失败: %v", err)
+	}
+	if err := mountRootfs(container); err != nil {
+		return err
+	}
+
+	fmt.Printf("准备容器文件系统: %s (使用镜像: %s, 存储驱动: %s)\n", container.Rootfs, container.Image, driver.Name())
+	return nil
+}
+
+// prepareIDMappedRootfs 创建容器层并以idmapped方式挂载镜像层
+// 可写层中的文件不经过映射，根目录的属主直接设为容器内root对应的主机ID
+func prepareIDMappedRootfs(container *ContainerInfo, driver storage.Driver, parent string) error {
+	if err := driver.Create(container.ID, parent); err != nil {
+		return fmt.Errorf("创建容器层失败: %v", err)
+	}
+
+	diff, err := driver.Diff(container.ID)
+	if err == nil {
+		uid, _ := mapToHost(container.Config.UidMap, 0)
+		gid, _ := mapToHost(container.Config.GidMap, 0)
+		err = os.Chown(diff, uid, gid)
+	}
+	if err == nil {
+		container.IDMappedRootfs = true
+		err = mountRootfs(container)
+	}
+	if err != nil {
+		container.IDMappedRootfs = false
+		driver.Remove(container.ID)
+		return err
+	}
+
+	fmt.Printf("准备容器文件系统: %s (使用镜像: %s, 存储驱动: %s, idmapped)\n", container.Rootfs, container.Image, driver.Name())
+	return nil
+}
+
+// mountRootfs 挂载容器的根文件系统，已经挂载时不做任何操作
+// 主机重启后挂载会丢失，启动容器前也会调用以重新挂载
+func mountRootfs(container *ContainerInfo) error {
+	driver, err := storage.GetDriver(container.StorageDriver)
+	if err != nil {
+		return err
+	}
+
+	var rootfs string
+	if container.IDMappedRootfs {
+		rootfs, err = mountIDMappedRootfs(container, driver)
+	} else {
+		rootfs, err = driver.Mount(container.ID)
+	}
+	if err != nil {
+		return err
+	}
+	container.Rootfs = rootfs
+	return nil
+}
+
+// mountIDMappedRootfs 按容器的ID映射挂载镜像层
+func mountIDMappedRootfs(container *ContainerInfo, driver storage.Driver) (string, error) {
+	mapper, ok := driver.(storage.IDMapper)
+	if !ok {
+		return "", fmt.Errorf("%w: 存储驱动 %s", storage.ErrIDMapUnsupported, driver.Name())
+	}
+
+	userns, err := openUserns(container.Config.UidMap, container.Config.GidMap)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", storage.ErrIDMapUnsupported, err)
+	}
+	defer userns.Close()
+
+	return mapper.MountIDMapped(container.ID, int(userns.Fd()))
+}
+
+// removeContainerDir 删除容器的可写层和容器目录
+func removeContainerDir(container *ContainerInfo) error {
+	if container.StorageDriver != "" {
+		driver, err := storage.GetDriver(container.StorageDriver)
+		if err != nil {
+			return err
+		}
+		if err := driver.Remove(container.ID); err != nil {
+			return err
+		}
+	}
+	// 监控进程异常退出时 /dev/shm 可能还没有卸载
+	unmountShm(shmPath(container.ID))
+	return os.RemoveAll(containerDir(container.ID))
+}
+
+// resolveInRootfs 把容器内的路径解析为主机上的路径
+// 路径中的符号链接按容器的根目录解析，保证结果不会超出rootfs
+func resolveInRootfs(rootfs, path string) (string, error) {
+	const maxSymlinks = 255
+
+	resolved := "/"
+	pending := strings.Split(path, "/")
+	links := 0
+
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		info, err := os.Lstat(filepath.Join(rootfs, next))
+		if err != nil {
+			if os.IsNotExist(err) {
+				resolved = next
+				continue
+			}
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("解析路径 %s 时符号链接过多", path)
+		}
+		link, err := os.Readlink(filepath.Join(rootfs, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(link) {
+			resolved = "/"
+		}
+		pending = append(strings.Split(link, "/"), pending...)
+	}
+
+	return filepath.Join(rootfs, resolved), nil
+}
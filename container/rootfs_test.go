@@ -0,0 +1,79 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveInRootfs(t *testing.T) {
+	rootfs := t.TempDir()
+	for _, dir := range []string{"etc", "usr/lib", "data"} {
+		if err := os.MkdirAll(filepath.Join(rootfs, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(rootfs, "etc/hosts"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"lib":          "usr/lib",
+		"abs":          "/etc",
+		"escape":       "../../../etc",
+		"escape-abs":   "/../../etc",
+		"data/up":      "..",
+		"data/host":    "/",
+		"data/chain":   "../lib",
+		"data/missing": "/nonexistent/dir",
+		"loop-a":       "loop-b",
+		"loop-b":       "loop-a",
+		"self":         "self",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(rootfs, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"普通路径", "/etc/hosts", "/etc/hosts", false},
+		{"相对路径", "etc/hosts", "/etc/hosts", false},
+		{"根目录", "/", "/", false},
+		{"多余的分隔符和点", "//etc/./hosts/", "/etc/hosts", false},
+		{"不存在的路径", "/no/such/file", "/no/such/file", false},
+		{"点点不能超出根目录", "/../../etc", "/etc", false},
+		{"中间的点点", "/usr/lib/../../etc/hosts", "/etc/hosts", false},
+		{"不存在的目录后接点点", "/no/such/../../etc", "/etc", false},
+		{"相对符号链接", "/lib", "/usr/lib", false},
+		{"符号链接后接点点", "/lib/../../etc", "/etc", false},
+		{"绝对符号链接", "/abs/hosts", "/etc/hosts", false},
+		{"相对符号链接超出根目录", "/escape/hosts", "/etc/hosts", false},
+		{"绝对符号链接中的点点", "/escape-abs", "/etc", false},
+		{"指向上级的符号链接", "/data/up/data/up/etc", "/etc", false},
+		{"指向根目录的符号链接", "/data/host/etc/hosts", "/etc/hosts", false},
+		{"多级符号链接", "/data/chain", "/usr/lib", false},
+		{"指向不存在路径的符号链接", "/data/missing/file", "/nonexistent/dir/file", false},
+		{"循环符号链接", "/loop-a", "", true},
+		{"指向自身的符号链接", "/self/x", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveInRootfs(rootfs, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveInRootfs(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if want := filepath.Join(rootfs, tt.want); got != want {
+				t.Errorf("resolveInRootfs(%q) = %q, want %q", tt.path, got, want)
+			}
+		})
+	}
+}
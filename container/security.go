@@ -0,0 +1,44 @@
+package container
+
+// 默认屏蔽的内核路径，会泄露主机信息或者可以影响主机
+var defaultMaskedPaths = []string{
+	"/proc/acpi",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+}
+
+// 默认只读的内核路径，写入会修改主机的内核参数
+var defaultReadonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// UnmaskAll --security-opt unmask=ALL，不屏蔽任何内核路径
+const UnmaskAll = "ALL"
+
+// unmaskPaths 从默认路径中去掉用户指定不屏蔽的路径
+func unmaskPaths(paths, unmask []string) []string {
+	var result []string
+	for _, path := range paths {
+		masked := true
+		for _, u := range unmask {
+			if u == UnmaskAll || u == path {
+				masked = false
+				break
+			}
+		}
+		if masked {
+			result = append(result, path)
+		}
+	}
+	return result
+}
@@ -0,0 +1,406 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/akm/godocker/network"
+	"github.com/akm/godocker/resources"
+	"github.com/akm/godocker/rootless"
+)
+
+const (
+	// 监控进程的错误输出文件，后台运行时监控进程没有终端可用
+	shimLogFileName = "shim.log"
+
+	// 前台运行时传给监控进程的参数，表示需要等待启动者连接
+	shimAttachArg = "--attach"
+
+	// 前台运行时等待启动者连接的最长时间
+	attachWaitTimeout = 10 * time.Second
+)
+
+// shimResult 监控进程通过同步管道返回给启动者的结果
+type shimResult struct {
+	Pid   int    // 容器进程ID
+	Error string // 启动失败的原因
+}
+
+// startShim 启动容器的监控进程，并等待其报告容器进程的启动结果
+// 监控进程是当前godocker程序以 shim 参数重新执行的子进程，它负责启动容器进程、
+// 持有容器的标准输入输出、回收容器进程并记录退出状态，在当前进程退出后继续运行。
+// attach为true时监控进程在第一个attach客户端连接之前不转发容器输出，避免丢失输出
+func startShim(container *ContainerInfo, attach bool) (int, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("创建同步管道失败: %v", err)
+	}
+	defer reader.Close()
+
+	shimLog, err := os.OpenFile(filepath.Join(containerDir(container.ID), shimLogFileName),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		writer.Close()
+		return 0, fmt.Errorf("创建监控进程日志失败: %v", err)
+	}
+	defer shimLog.Close()
+
+	shim := exec.Command("/proc/self/exe", "shim", container.ID)
+	if attach {
+		shim.Args = append(shim.Args, shimAttachArg)
+	}
+	shim.ExtraFiles = []*os.File{writer}
+	shim.Stdout = shimLog
+	shim.Stderr = shimLog
+	// 脱离当前会话，避免终端关闭或终端信号结束监控进程
+	shim.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err = shim.Start()
+	writer.Close()
+	if err != nil {
+		return 0, fmt.Errorf("启动监控进程失败: %v", err)
+	}
+
+	// 监控进程会一直运行到容器退出，这里不等待它结束
+	go shim.Wait()
+
+	var result shimResult
+	if err := json.NewDecoder(reader).Decode(&result); err != nil {
+		if err == io.EOF {
+			return 0, fmt.Errorf("监控进程异常退出")
+		}
+		return 0, fmt.Errorf("读取监控进程结果失败: %v", err)
+	}
+	if result.Error != "" {
+		return 0, fmt.Errorf("%s", result.Error)
+	}
+
+	return result.Pid, nil
+}
+
+// RunShim 容器监控进程的入口
+// 启动结果通过文件描述符3报告给启动者，之后一直运行到容器进程退出
+func RunShim(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("缺少容器ID")
+	}
+	containerId := args[0]
+	attach := len(args) > 1 && args[1] == shimAttachArg
+
+	syncPipe := os.NewFile(3, "shim-sync")
+	if syncPipe == nil {
+		return fmt.Errorf("缺少同步管道")
+	}
+
+	shim, err := shimStartContainer(containerId, attach)
+
+	// 报告启动结果后关闭同步管道，启动者随即返回
+	result := shimResult{}
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Pid = shim.cmd.Process.Pid
+	}
+	json.NewEncoder(syncPipe).Encode(&result)
+	syncPipe.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return shim.wait()
+}
+
+// containerShim 监控进程中正在看护的容器
+type containerShim struct {
+	containerId   string
+	cmd           *exec.Cmd
+	server        *attachServer
+	logFile       *jsonLogFile
+	attach        bool           // 是否等待第一个attach客户端连接后再转发输出
+	consoleSocket *os.File       // 接收容器终端的socket，非终端模式为nil
+	copying       sync.WaitGroup // 等待容器输出全部处理完
+}
+
+// shimStartContainer 在监控进程中启动容器进程，并记录容器状态为运行中
+func shimStartContainer(containerId string, attach bool) (*containerShim, error) {
+	container, err := readContainerInfo(containerId)
+	if err != nil {
+		return nil, fmt.Errorf("读取容器信息失败: %v", err)
+	}
+
+	if !container.Status.CanTransition(StatusRunning) {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, container.Status, StatusRunning)
+	}
+
+	// 主机重启后根文件系统的挂载会丢失，启动前确保已经挂载
+	if err := mountRootfs(container); err != nil {
+		return nil, err
+	}
+	if err := mountVolumes(container); err != nil {
+		return nil, err
+	}
+	if container.Config.isolatesNamespace("ipc") {
+		if err := mountShm(shmPath(container.ID), container.Config.ShmSize); err != nil {
+			return nil, err
+		}
+	}
+	// 容器没有启动成功时卸载 /dev/shm，容器启动后由 wait 在容器退出时卸载
+	started := false
+	defer func() {
+		if !started {
+			unmountShm(shmPath(container.ID))
+		}
+	}()
+
+	shim := &containerShim{containerId: containerId, attach: attach}
+
+	stdio, err := shim.setupStdio(container)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, configPipe, err := startContainer(container, stdio)
+
+	// 容器的输入输出已经交给容器进程，关闭监控进程持有的副本，
+	// 这样容器退出后日志收集才能读到EOF
+	for _, f := range []*os.File{stdio.stdin, stdio.stdout, stdio.stderr, stdio.console} {
+		if f != nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		shim.closeOutput(ExitCodeUnknown)
+		return nil, err
+	}
+	shim.cmd = cmd
+	shim.server.process = cmd.Process
+
+	if err := shim.prepareContainer(container); err != nil {
+		configPipe.Close()
+		shim.kill()
+		return nil, err
+	}
+
+	// 容器环境准备好之后再把配置交给init进程，init进程在此之前一直阻塞
+	err = writeConfigPipe(configPipe, newInitConfig(container))
+	configPipe.Close()
+	if err != nil {
+		shim.kill()
+		return nil, err
+	}
+
+	// 终端模式下等待容器init进程发来终端主设备
+	if shim.consoleSocket != nil {
+		console, err := receiveConsole(shim.consoleSocket)
+		shim.consoleSocket.Close()
+		if err != nil {
+			shim.kill()
+			return nil, err
+		}
+		shim.server.setConsole(console)
+		shim.copying.Add(1)
+		go shim.copyOutput(streamStdout, console, frameStdout)
+	}
+	go shim.server.serve()
+
+	started = true
+	return shim, nil
+}
+
+// prepareContainer 记录容器进程并为其应用资源限制和网络配置
+func (s *containerShim) prepareContainer(container *ContainerInfo) error {
+	// 记录进程ID和启动时间
+	if err := container.setRunning(s.cmd.Process.Pid); err != nil {
+		return err
+	}
+	container.ShimPid = os.Getpid()
+	container.ShimStart = processStartTime(container.ShimPid)
+
+	if err := saveContainerInfo(container); err != nil {
+		return err
+	}
+
+	// 应用资源限制，容器只能访问标准设备和映射的主机设备。
+	// 容器进程可以创建设备文件，设备限制无法应用时不能启动容器。
+	// rootless模式下无法使用cgroup，创建容器时已经给出警告，容器本身也没有权限访问主机设备
+	if !rootless.Enabled() {
+		resource := container.Config.Resource
+		resource.Devices = deviceRules(container.Config.Devices)
+		if err := resources.ApplyResourceLimits(s.cmd.Process.Pid, resource); err != nil {
+			return fmt.Errorf("应用资源限制失败: %v", err)
+		}
+	}
+
+	if container.Network != nil && container.Network.Mode != network.NoneMode {
+		if err := network.SetupNetwork(container.Network, container.ID, container.Pid); err != nil {
+			return fmt.Errorf("容器网络配置失败: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// setupStdio 准备容器进程的标准输入输出并启动attach服务
+// 容器输出写入日志文件，同时转发给所有attach客户端。
+// 终端模式下容器在自己的 /dev/pts 中分配伪终端，输入输出都经过终端主设备；
+// 这里的管道只用于收集init进程在创建终端之前的输出。
+// 非终端模式下使用 -i 时，attach客户端的输入通过管道写入容器的标准输入
+func (s *containerShim) setupStdio(container *ContainerInfo) (containerStdio, error) {
+	var stdio containerStdio
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	if container.Config.Tty {
+		parent, child, err := newConsoleSocket()
+		if err != nil {
+			return stdio, err
+		}
+		files = append(files, parent, child)
+		s.consoleSocket, stdio.console = parent, child
+	}
+
+	newPipe := func() (*os.File, *os.File, error) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("创建输入输出管道失败: %v", err)
+		}
+		files = append(files, r, w)
+		return r, w, nil
+	}
+
+	stdoutReader, stdoutWriter, err := newPipe()
+	if err != nil {
+		return stdio, err
+	}
+	stderrReader, stderrWriter, err := newPipe()
+	if err != nil {
+		return stdio, err
+	}
+	stdio.stdout, stdio.stderr = stdoutWriter, stderrWriter
+
+	var stdinWriter *os.File
+	if container.Config.Stdin && !container.Config.Tty {
+		if stdio.stdin, stdinWriter, err = newPipe(); err != nil {
+			return stdio, err
+		}
+	}
+
+	logFile, err := openJSONLogFile(container.ID)
+	if err != nil {
+		closeAll()
+		return stdio, err
+	}
+	s.logFile = logFile
+
+	server, err := newAttachServer(container.ID)
+	if err != nil {
+		logFile.Close()
+		closeAll()
+		return stdio, err
+	}
+	s.server = server
+	server.stdin = stdinWriter
+
+	s.copying.Add(2)
+	go s.copyOutput(streamStdout, stdoutReader, frameStdout)
+	go s.copyOutput(streamStderr, stderrReader, frameStderr)
+
+	return stdio, nil
+}
+
+// copyOutput 把容器的一路输出写入日志并转发给attach客户端，读完后关闭
+func (s *containerShim) copyOutput(stream string, reader *os.File, frameType byte) {
+	defer s.copying.Done()
+	defer reader.Close()
+	// 前台运行时等待启动者连接后再转发输出
+	if s.attach {
+		s.server.waitFirstClient(attachWaitTimeout)
+	}
+	copyLogStream(s.logFile, stream, reader, s.server.stream(frameType))
+}
+
+// closeOutput 等待容器输出处理完，关闭日志文件并通知attach客户端容器已退出
+func (s *containerShim) closeOutput(exitCode int) {
+	s.copying.Wait()
+	s.logFile.Close()
+	s.server.close(exitCode)
+}
+
+// kill 强制结束容器进程并清理
+// 容器状态可能已经记录为运行中，需要同时记录为已退出
+func (s *containerShim) kill() {
+	s.cmd.Process.Kill()
+	s.cmd.Wait()
+	resources.RemoveResourceLimits(s.cmd.Process.Pid)
+	unmountShm(shmPath(s.containerId))
+	if err := s.recordExit(ExitCodeUnknown, false); err != nil {
+		fmt.Printf("记录容器退出状态失败: %v\n", err)
+	}
+	s.closeOutput(ExitCodeUnknown)
+}
+
+// wait 等待容器进程退出，记录退出码和退出时间并通知attach客户端
+func (s *containerShim) wait() error {
+	// 将收到的信号转发给容器进程，监控进程自身不因终端信号退出
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGCHLD || sig == syscall.SIGURG || sig == syscall.SIGPIPE {
+				continue
+			}
+			s.cmd.Process.Signal(sig)
+		}
+	}()
+
+	pid := s.cmd.Process.Pid
+	s.cmd.Wait()
+	exitCode := exitCodeFromState(s.cmd.ProcessState)
+
+	// 等待容器输出全部写入日志并发送给客户端后再更新状态
+	s.copying.Wait()
+	signal.Stop(signals)
+
+	oomKilled := resources.OOMKilled(pid)
+	resources.RemoveResourceLimits(pid)
+	unmountShm(shmPath(s.containerId))
+	err := s.recordExit(exitCode, oomKilled)
+
+	// 状态保存后再通知客户端，客户端退出时容器状态已经是最新的
+	s.closeOutput(exitCode)
+
+	return err
+}
+
+// recordExit 记录容器退出状态
+func (s *containerShim) recordExit(exitCode int, oomKilled bool) error {
+	container, err := readContainerInfo(s.containerId)
+	if err != nil {
+		return fmt.Errorf("读取容器信息失败: %v", err)
+	}
+	if !container.Status.IsActive() {
+		return nil
+	}
+
+	if err := container.setExited(exitCode, oomKilled); err != nil {
+		return err
+	}
+	container.ShimPid = 0
+	container.ShimStart = 0
+
+	return saveContainerInfo(container)
+}
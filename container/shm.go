@@ -0,0 +1,61 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/akm/godocker/storage"
+)
+
+// shmPath 返回容器 /dev/shm 在主机上的挂载点
+func shmPath(containerId string) string {
+	return filepath.Join(containerDir(containerId), "shm")
+}
+
+// shmSource 返回绑定挂载到容器 /dev/shm 的主机目录
+// 共享IPC namespace时POSIX共享内存也要共享：与主机共享时使用主机的 /dev/shm，
+// 加入其他容器或pod时使用它们的 /dev/shm，否则使用容器自己的
+func shmSource(container *ContainerInfo) string {
+	mode := container.Config.namespaceMode("ipc")
+	if mode == NamespaceHost {
+		return "/dev/shm"
+	}
+	if strings.HasPrefix(mode, NamespaceContainerPrefix) {
+		return shmPath(strings.TrimPrefix(mode, NamespaceContainerPrefix))
+	}
+	if strings.HasPrefix(mode, podNamespacePrefix) {
+		return podShmPath(strings.TrimPrefix(mode, podNamespacePrefix))
+	}
+	return shmPath(container.ID)
+}
+
+// mountShm 在主机上挂载容器 /dev/shm 使用的tmpfs，size为0时使用 DefaultShmSize，已经挂载时不做任何操作
+// 在容器自己的mount namespace中挂载的tmpfs无法再绑定挂载到其他容器，因此在主机上挂载
+func mountShm(path string, size int64) error {
+	if mounted, err := storage.IsMountPoint(path); err != nil || mounted {
+		return err
+	}
+	if err := os.MkdirAll(path, 01777); err != nil {
+		return fmt.Errorf("创建 /dev/shm 目录失败: %v", err)
+	}
+
+	if size == 0 {
+		size = DefaultShmSize
+	}
+	flags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
+	if err := mountFilesystem("shm", path, "tmpfs", flags, fmt.Sprintf("mode=1777,size=%d", size)); err != nil {
+		return fmt.Errorf("挂载 /dev/shm 失败: %v", err)
+	}
+	return nil
+}
+
+// unmountShm 卸载主机上的 /dev/shm 挂载点
+// 加入了该IPC namespace的容器中的绑定挂载不受影响，共享内存在它们退出后才释放
+func unmountShm(path string) {
+	if mounted, err := storage.IsMountPoint(path); err == nil && mounted {
+		syscall.Unmount(path, syscall.MNT_DETACH)
+	}
+}
@@ -0,0 +1,230 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// 容器状态文件名，位于 DefaultContainerRoot/<id>/ 下
+	configFileName = "config.json"
+)
+
+// containerDir 返回容器的状态目录
+func containerDir(containerId string) string {
+	return filepath.Join(DefaultContainerRoot, containerId)
+}
+
+// saveContainerInfo 将容器信息持久化到磁盘
+// 先写临时文件再重命名，避免并发读取到写了一半的文件
+func saveContainerInfo(container *ContainerInfo) error {
+	dir := containerDir(container.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建容器目录失败: %v", err)
+	}
+
+	data, err := json.MarshalIndent(container, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化容器信息失败: %v", err)
+	}
+
+	tmpFile := filepath.Join(dir, configFileName+".tmp")
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return fmt.Errorf("写入容器信息失败: %v", err)
+	}
+
+	if err := os.Rename(tmpFile, filepath.Join(dir, configFileName)); err != nil {
+		return fmt.Errorf("保存容器信息失败: %v", err)
+	}
+
+	return nil
+}
+
+// readContainerInfo 从磁盘读取容器信息，不检查进程状态
+func readContainerInfo(containerId string) (*ContainerInfo, error) {
+	data, err := os.ReadFile(filepath.Join(containerDir(containerId), configFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var container ContainerInfo
+	if err := json.Unmarshal(data, &container); err != nil {
+		return nil, fmt.Errorf("解析容器信息失败: %v", err)
+	}
+
+	return &container, nil
+}
+
+// loadContainerInfo 从磁盘读取容器信息，并修正已退出进程的状态
+func loadContainerInfo(containerId string) (*ContainerInfo, error) {
+	container, err := readContainerInfo(containerId)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshStatus(container)
+
+	return container, nil
+}
+
+// loadAllContainers 读取所有已持久化的容器
+func loadAllContainers() ([]*ContainerInfo, error) {
+	entries, err := os.ReadDir(DefaultContainerRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("读取容器目录失败: %v", err)
+	}
+
+	var containers []*ContainerInfo
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		// 数据目录下还有镜像等其他目录，没有状态文件的直接跳过
+		container, err := loadContainerInfo(entry.Name())
+		if err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Printf("警告: 读取容器 %s 失败: %v\n", entry.Name(), err)
+			}
+			continue
+		}
+
+		containers = append(containers, container)
+	}
+
+	return containers, nil
+}
+
+// findContainer 根据容器ID、ID前缀或容器名称查找容器
+func findContainer(ref string) (*ContainerInfo, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("容器ID不能为空")
+	}
+
+	containers, err := loadAllContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []*ContainerInfo
+	for _, c := range containers {
+		// 完整ID或名称精确匹配时直接返回
+		if c.ID == ref || c.Name == ref {
+			return c, nil
+		}
+		if strings.HasPrefix(c.ID, ref) {
+			matched = append(matched, c)
+		}
+	}
+
+	switch len(matched) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", ErrContainerNotFound, ref)
+	case 1:
+		return matched[0], nil
+	default:
+		return nil, fmt.Errorf("容器ID前缀 %s 匹配到多个容器", ref)
+	}
+}
+
+// refreshStatus 检查记录为运行中的容器进程是否仍然存活
+// 监控进程存活时由它负责记录退出状态；监控进程和容器进程都已退出
+// （或PID已被其他进程复用）时将容器标记为已退出
+func refreshStatus(container *ContainerInfo) {
+	if !container.Status.IsActive() {
+		return
+	}
+
+	if processAlive(container.ShimPid, container.ShimStart) {
+		return
+	}
+
+	if processAlive(container.Pid, container.PidStartTime) {
+		return
+	}
+
+	// 进程已不存在，无法得知真实的退出码
+	if err := container.setExited(ExitCodeUnknown, false); err != nil {
+		return
+	}
+	if err := saveContainerInfo(container); err != nil {
+		fmt.Printf("警告: 更新容器 %s 状态失败: %v\n", container.ID, err)
+	}
+}
+
+// processAlive 判断进程是否存活
+// startTime 非0时同时校验进程启动时间，防止PID复用导致误判
+func processAlive(pid int, startTime uint64) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	// 发送0号信号只检查进程是否存在，EPERM说明进程存在但无权限
+	if err := syscall.Kill(pid, 0); err != nil && err != syscall.EPERM {
+		return false
+	}
+
+	stat, err := readProcStat(pid)
+	if err != nil {
+		// 无法读取/proc时只能依赖kill的结果
+		return true
+	}
+
+	// 僵尸进程已经退出，只是还没有被回收
+	if stat.state == "Z" || stat.state == "X" {
+		return false
+	}
+
+	return startTime == 0 || stat.startTime == startTime
+}
+
+// procStat /proc/<pid>/stat 中用到的字段
+type procStat struct {
+	state     string // 进程状态
+	startTime uint64 // 进程启动时间（系统启动后的时钟节拍数）
+}
+
+// readProcStat 读取进程的 /proc/<pid>/stat
+func readProcStat(pid int) (*procStat, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	// 进程名可能包含空格和括号，从最后一个右括号之后开始解析
+	content := string(data)
+	idx := strings.LastIndex(content, ")")
+	if idx < 0 {
+		return nil, fmt.Errorf("无效的stat格式")
+	}
+
+	// 右括号之后第一个字段是state（第3个字段），starttime是第22个字段
+	fields := strings.Fields(content[idx+1:])
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("无效的stat格式")
+	}
+
+	startTime, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("解析进程启动时间失败: %v", err)
+	}
+
+	return &procStat{state: fields[0], startTime: startTime}, nil
+}
+
+// processStartTime 获取进程启动时间，读取失败时返回0
+func processStartTime(pid int) uint64 {
+	stat, err := readProcStat(pid)
+	if err != nil {
+		return 0
+	}
+	return stat.startTime
+}
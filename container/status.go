@@ -0,0 +1,109 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Status 容器生命周期状态
+type Status string
+
+const (
+	StatusCreated    Status = "created"    // 已创建，尚未启动
+	StatusRunning    Status = "running"    // 运行中
+	StatusPaused     Status = "paused"     // 已暂停
+	StatusRestarting Status = "restarting" // 重启中
+	StatusExited     Status = "exited"     // 已退出
+	StatusDead       Status = "dead"       // 已失效（如删除失败），不可再启动
+)
+
+// 状态的中文名称，用于命令行展示
+var statusNames = map[Status]string{
+	StatusCreated:    "已创建",
+	StatusRunning:    "运行中",
+	StatusPaused:     "已暂停",
+	StatusRestarting: "重启中",
+	StatusExited:     "已退出",
+	StatusDead:       "已失效",
+}
+
+// 允许的状态转换表
+var statusTransitions = map[Status][]Status{
+	StatusCreated:    {StatusRunning, StatusDead},
+	StatusRunning:    {StatusPaused, StatusRestarting, StatusExited, StatusDead},
+	StatusPaused:     {StatusRunning, StatusExited, StatusDead},
+	StatusRestarting: {StatusRunning, StatusExited, StatusDead},
+	StatusExited:     {StatusRunning, StatusRestarting, StatusDead},
+	StatusDead:       {},
+}
+
+// 容器状态相关的错误，调用方可以使用 errors.Is 判断
+var (
+	ErrContainerNotRunning = errors.New("容器未在运行")
+	ErrContainerRunning    = errors.New("容器正在运行")
+	ErrInvalidTransition   = errors.New("非法的容器状态转换")
+	ErrContainerNotFound   = errors.New("找不到容器")
+)
+
+// ExitCodeUnknown 无法获取容器退出码时记录的值
+const ExitCodeUnknown = -1
+
+// String 返回状态的中文名称
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return string(s)
+}
+
+// IsActive 判断该状态下容器进程是否存在
+func (s Status) IsActive() bool {
+	return s == StatusRunning || s == StatusPaused || s == StatusRestarting
+}
+
+// CanTransition 判断是否允许从当前状态转换到目标状态
+func (s Status) CanTransition(to Status) bool {
+	for _, next := range statusTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transition 将容器切换到目标状态，非法转换返回 ErrInvalidTransition
+func (c *ContainerInfo) transition(to Status) error {
+	if !c.Status.CanTransition(to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, c.Status, to)
+	}
+	c.Status = to
+	return nil
+}
+
+// setRunning 记录容器进程已启动
+func (c *ContainerInfo) setRunning(pid int) error {
+	if err := c.transition(StatusRunning); err != nil {
+		return err
+	}
+	c.Pid = pid
+	c.PidStartTime = processStartTime(pid)
+	c.StartedAt = time.Now()
+	c.FinishedAt = time.Time{}
+	c.ExitCode = 0
+	c.OOMKilled = false
+	return nil
+}
+
+// setExited 记录容器进程已退出
+func (c *ContainerInfo) setExited(exitCode int, oomKilled bool) error {
+	if err := c.transition(StatusExited); err != nil {
+		return err
+	}
+	c.Pid = 0
+	c.PidStartTime = 0
+	c.ExitCode = exitCode
+	c.OOMKilled = oomKilled
+	c.FinishedAt = time.Now()
+	return nil
+}
@@ -4,11 +4,16 @@
 package container
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"syscall"
 
+	"github.com/akm/godocker/network"
 	"golang.org/x/sys/unix"
 )
 
@@ -23,15 +28,385 @@ func mountFilesystem(source, target, fstype string, flags int, data string) erro
 }
 
 // setNamespaceFlags 设置Linux特定的namespace隔离标志
-func setNamespaceFlags(attr *syscall.SysProcAttr) {
-	attr.Cloneflags = syscall.CLONE_NEWUTS | // 隔离主机名
-		syscall.CLONE_NEWPID | // 隔离进程ID
-		syscall.CLONE_NEWNS | // 隔离挂载点
-		syscall.CLONE_NEWNET // 隔离网络
+func setNamespaceFlags(attr *syscall.SysProcAttr, config *Config) {
+	attr.Cloneflags = syscall.CLONE_NEWNS // 隔离挂载点
+	if config.isolatesNamespace("uts") {
+		attr.Cloneflags |= syscall.CLONE_NEWUTS // 隔离主机名
+	}
+	if config.isolatesNamespace("pid") {
+		attr.Cloneflags |= syscall.CLONE_NEWPID // 隔离进程ID
+	}
+	if config.isolatesNamespace("ipc") {
+		attr.Cloneflags |= syscall.CLONE_NEWIPC // 隔离System V IPC和POSIX消息队列
+	}
+	if config.isolatesNamespace("net") {
+		attr.Cloneflags |= syscall.CLONE_NEWNET // 隔离网络
+	}
+
+	// 隔离时钟，容器内的CLOCK_MONOTONIC和CLOCK_BOOTTIME可以有自己的偏移。
+	// CLONE_NEWTIME与clone的退出信号位冲突，只能通过unshare创建，
+	// unshare后调用进程自己仍在原来的time namespace中，init执行exec时才会进入新的namespace
+	if namespaceSupported("time") {
+		attr.Unshareflags |= unix.CLONE_NEWTIME
+	}
+
+	// 隔离用户，容器内的root映射为主机上的普通用户
+	// 映射由父进程在子进程执行init之前写入 /proc/<pid>/uid_map 和 gid_map
+	if config.usernsEnabled() {
+		attr.Cloneflags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = sysProcIDMap(config.UidMap)
+		attr.GidMappings = sysProcIDMap(config.GidMap)
+		// 允许init进程调用setgroups切换到容器内的用户
+		attr.GidMappingsEnableSetgroups = true
+		// 主机root在新的user namespace中没有映射，exec后会失去所有权限，
+		// 需要在exec之前切换为容器内的root
+		attr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+	}
+}
+
+// setPodNamespaceFlags 设置pod infra进程的namespace隔离标志
+// infra进程只持有成员容器共享的namespace，使用主机网络的pod不创建network namespace
+func setPodNamespaceFlags(attr *syscall.SysProcAttr, netMode string) {
+	attr.Cloneflags = syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	if netMode != network.HostMode {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+}
+
+// startInNamespaces 在其他容器的namespace中启动进程，namespaces 的值为 /proc/<pid>/ns/<名称>
+// setns只对调用线程生效，子进程会继承创建它的线程的namespace（pid namespace是在其中创建子进程）。
+// 因此在单独锁定的线程中加入namespace后再启动进程；该线程的namespace已经改变，
+// goroutine结束时不解锁，由Go运行时销毁这个线程，不会被其他goroutine使用
+func startInNamespaces(cmd *exec.Cmd, namespaces map[string]string) error {
+	if len(namespaces) == 0 {
+		return cmd.Start()
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		for ns, path := range namespaces {
+			fd, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+			if err != nil {
+				result <- fmt.Errorf("打开 %s 失败: %v", path, err)
+				return
+			}
+			err = unix.Setns(fd, 0)
+			unix.Close(fd)
+			if err != nil {
+				result <- fmt.Errorf("加入 %s namespace失败: %v", ns, err)
+				return
+			}
+		}
+		result <- cmd.Start()
+	}()
+	return <-result
+}
+
+// enterCgroupNamespace 创建新的cgroup namespace，以init当前所在的cgroup作为根
+// 需要在监控进程把init加入资源限制的cgroup之后调用，因此不能在clone时创建。
+// namespace只对调用线程生效，调用方需要锁定当前线程直到exec
+func enterCgroupNamespace() error {
+	if !namespaceSupported("cgroup") {
+		return nil
+	}
+	return unix.Unshare(unix.CLONE_NEWCGROUP)
+}
+
+func sysProcIDMap(mappings []IDMapping) []syscall.SysProcIDMap {
+	result := make([]syscall.SysProcIDMap, len(mappings))
+	for i, m := range mappings {
+		result[i] = syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size}
+	}
+	return result
+}
+
+// openUserns 创建一个使用指定ID映射的user namespace，返回它的文件描述符，用于idmapped mount
+// 子进程在exec后因ptrace停止，不会执行任何代码；打开namespace后即杀死，namespace由文件描述符保持。
+// ptrace要求启动和等待子进程在同一个线程中进行
+func openUserns(uidMap, gidMap []IDMapping) (*os.File, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cmd := exec.Command("/proc/self/exe")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER,
+		UidMappings: sysProcIDMap(uidMap),
+		GidMappings: sysProcIDMap(gidMap),
+		Ptrace:      true,
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("创建user namespace失败: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	file, err := os.Open(fmt.Sprintf("/proc/%d/ns/user", cmd.Process.Pid))
+	if err != nil {
+		return nil, fmt.Errorf("打开user namespace失败: %v", err)
+	}
+	return file, nil
+}
+
+// prepareRoot 把整个挂载树设为私有传播，并把rootfs绑定挂载到自身
+// 之后容器内的挂载不会传播回主机，rootfs也成为pivot_root要求的挂载点。
+// 有数据卷使用slave或shared传播时改为从属传播，这样主机上的挂载仍然可以传播到这些数据卷
+func prepareRoot(rootfs string, volumes []VolumeMapping) error {
+	flags := syscall.MS_REC | syscall.MS_PRIVATE
+	for _, volume := range volumes {
+		if flag := propagationFlags[volume.Propagation]; flag&(syscall.MS_SLAVE|syscall.MS_SHARED) != 0 {
+			flags = syscall.MS_REC | syscall.MS_SLAVE
+		}
+	}
+
+	if err := mountFilesystem("", "/", "", flags, ""); err != nil {
+		return fmt.Errorf("设置根目录挂载传播失败: %v", err)
+	}
+	if err := mountFilesystem(rootfs, rootfs, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("绑定挂载rootfs失败: %v", err)
+	}
+	return nil
+}
+
+// pivotRoot 把rootfs切换为根目录并卸载原来的根目录
+// pivot_root(".", ".") 把原根目录叠放在新根目录之上，不需要额外创建put_old目录，
+// 随后卸载位于最上层的原根目录，容器内就看不到主机的挂载点了
+func pivotRoot(rootfs string) error {
+	oldRoot, err := unix.Open("/", unix.O_DIRECTORY|unix.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("打开原根目录失败: %v", err)
+	}
+	defer unix.Close(oldRoot)
+
+	newRoot, err := unix.Open(rootfs, unix.O_DIRECTORY|unix.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("打开rootfs失败: %v", err)
+	}
+	defer unix.Close(newRoot)
+
+	if err := unix.Fchdir(newRoot); err != nil {
+		return fmt.Errorf("切换到rootfs失败: %v", err)
+	}
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("pivot_root失败: %v", err)
+	}
+
+	// 回到原根目录所在的位置将其卸载，卸载前设为从属传播，避免卸载事件传播到主机
+	if err := unix.Fchdir(oldRoot); err != nil {
+		return fmt.Errorf("切换到原根目录失败: %v", err)
+	}
+	if err := mountFilesystem("", ".", "", syscall.MS_SLAVE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("设置原根目录挂载传播失败: %v", err)
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("卸载原根目录失败: %v", err)
+	}
+
+	return os.Chdir("/")
+}
+
+// 挂载传播方式对应的挂载标志
+var propagationFlags = map[string]int{
+	PropagationPrivate:  syscall.MS_PRIVATE,
+	PropagationRPrivate: syscall.MS_PRIVATE | syscall.MS_REC,
+	PropagationShared:   syscall.MS_SHARED,
+	PropagationRShared:  syscall.MS_SHARED | syscall.MS_REC,
+	PropagationSlave:    syscall.MS_SLAVE,
+	PropagationRSlave:   syscall.MS_SLAVE | syscall.MS_REC,
+}
+
+// setupVolumes 把主机目录或文件绑定挂载到容器中
+// 主机路径不存在时创建为目录，容器内的挂载点不存在时按主机路径的类型创建
+func setupVolumes(rootfs string, volumes []VolumeMapping) error {
+	for _, volume := range volumes {
+		info, err := os.Stat(volume.HostPath)
+		if os.IsNotExist(err) {
+			if err := os.MkdirAll(volume.HostPath, 0755); err != nil {
+				return fmt.Errorf("创建主机目录 %s 失败: %v", volume.HostPath, err)
+			}
+			info, err = os.Stat(volume.HostPath)
+		}
+		if err != nil {
+			return err
+		}
+
+		// 在rootfs内解析符号链接，避免镜像中的符号链接把挂载点指向主机的目录
+		target, err := resolveInRootfs(rootfs, volume.ContainerPath)
+		if err != nil {
+			return err
+		}
+		if err := createMountTarget(target, info.IsDir()); err != nil {
+			return fmt.Errorf("创建挂载点 %s 失败: %v", volume.ContainerPath, err)
+		}
+
+		if err := mountFilesystem(volume.HostPath, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("挂载 %s 到 %s 失败: %v", volume.HostPath, volume.ContainerPath, err)
+		}
+
+		// 绑定挂载时会忽略只读标志，需要重新挂载一次
+		if volume.ReadOnly {
+			if err := remountReadonly(target); err != nil {
+				return fmt.Errorf("设置 %s 只读失败: %v", volume.ContainerPath, err)
+			}
+		}
+
+		propagation := volume.Propagation
+		if propagation == "" {
+			propagation = PropagationRPrivate
+		}
+		if err := mountFilesystem("", target, "", propagationFlags[propagation], ""); err != nil {
+			return fmt.Errorf("设置 %s 挂载传播方式失败: %v", volume.ContainerPath, err)
+		}
+	}
+	return nil
+}
+
+// createMountTarget 创建挂载点，挂载目录时创建目录，挂载文件时创建空文件
+func createMountTarget(target string, dir bool) error {
+	if dir {
+		return os.MkdirAll(target, 0755)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// tmpfs挂载选项中对应挂载标志的选项，其余选项作为文件系统参数传给tmpfs
+var tmpfsFlagOptions = map[string]struct {
+	clear bool
+	flag  int
+}{
+	"ro":     {false, syscall.MS_RDONLY},
+	"rw":     {true, syscall.MS_RDONLY},
+	"noexec": {false, syscall.MS_NOEXEC},
+	"exec":   {true, syscall.MS_NOEXEC},
+	"nosuid": {false, syscall.MS_NOSUID},
+	"suid":   {true, syscall.MS_NOSUID},
+	"nodev":  {false, syscall.MS_NODEV},
+	"dev":    {true, syscall.MS_NODEV},
+}
+
+// parseTmpfsOptions 把tmpfs挂载选项拆分为挂载标志和文件系统参数
+// 默认带有 noexec,nosuid,nodev，可以用 exec 等选项取消
+func parseTmpfsOptions(options string) (int, string) {
+	flags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
+	var data []string
+	for _, option := range strings.Split(options, ",") {
+		if option == "" {
+			continue
+		}
+		if opt, ok := tmpfsFlagOptions[option]; ok {
+			if opt.clear {
+				flags &^= opt.flag
+			} else {
+				flags |= opt.flag
+			}
+			continue
+		}
+		data = append(data, option)
+	}
+	return flags, strings.Join(data, ",")
+}
+
+// setupTmpfs 在容器中挂载tmpfs
+func setupTmpfs(rootfs string, mounts []TmpfsMount) error {
+	for _, mount := range mounts {
+		target, err := resolveInRootfs(rootfs, mount.Path)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("创建挂载点 %s 失败: %v", mount.Path, err)
+		}
+
+		flags, data := parseTmpfsOptions(mount.Options)
+		if err := mountFilesystem("tmpfs", target, "tmpfs", flags, data); err != nil {
+			return fmt.Errorf("挂载tmpfs到 %s 失败: %v", mount.Path, err)
+		}
+	}
+	return nil
+}
+
+// maskPaths 屏蔽容器内的路径：文件绑定挂载 /dev/null，目录挂载只读的空tmpfs
+// 在切换根目录之后调用，路径不存在时跳过
+func maskPaths(paths []string) error {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			err = mountFilesystem("tmpfs", path, "tmpfs", syscall.MS_RDONLY, "size=0")
+		} else {
+			err = mountFilesystem("/dev/null", path, "", syscall.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("屏蔽 %s 失败: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// readonlyPaths 把容器内的路径绑定挂载到自身后重新挂载为只读
+// 在切换根目录之后调用，路径不存在时跳过
+func readonlyPaths(paths []string) error {
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := mountFilesystem(path, path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("绑定挂载 %s 失败: %v", path, err)
+		}
+		if err := remountReadonly(path); err != nil {
+			return fmt.Errorf("设置 %s 只读失败: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// 重新挂载时需要保留的挂载标志
+// 在user namespace中，从主机继承的这些标志被内核锁定，重新挂载时去掉它们会返回EPERM
+var lockedMountFlags = map[int64]int{
+	unix.ST_NOSUID:     syscall.MS_NOSUID,
+	unix.ST_NODEV:      syscall.MS_NODEV,
+	unix.ST_NOEXEC:     syscall.MS_NOEXEC,
+	unix.ST_NOATIME:    syscall.MS_NOATIME,
+	unix.ST_NODIRATIME: syscall.MS_NODIRATIME,
+	unix.ST_RELATIME:   syscall.MS_RELATIME,
+}
+
+// remountReadonly 把绑定挂载点重新挂载为只读，只影响这一个挂载点，不影响其下的子挂载
+func remountReadonly(path string) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return err
+	}
+
+	flags := syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY
+	for stFlag, msFlag := range lockedMountFlags {
+		if int64(st.Flags)&stFlag != 0 {
+			flags |= msFlag
+		}
+	}
+	return mountFilesystem("", path, "", flags, "")
 }
 
 // setupContainerMounts 设置容器的挂载点
-func setupContainerMounts(rootfs string) error {
+// shmSource 为绑定挂载到 /dev/shm 的主机目录，见 shmSource
+func setupContainerMounts(rootfs string, userns bool, shmSource string) error {
 	// 创建挂载点目录
 	for _, dir := range []string{"/proc", "/sys", "/dev", "/dev/pts", "/tmp"} {
 		path := filepath.Join(rootfs, dir)
@@ -41,12 +416,13 @@ func setupContainerMounts(rootfs string) error {
 	}
 
 	// 挂载 proc 文件系统
-	if err := mountFilesystem("proc", filepath.Join(rootfs, "/proc"), "proc", 0, ""); err != nil {
+	if err := mountKernelFilesystem("proc", filepath.Join(rootfs, "/proc"), 0, userns); err != nil {
 		return fmt.Errorf("挂载 proc 失败: %v", err)
 	}
 
-	// 挂载 sysfs 文件系统
-	if err := mountFilesystem("sysfs", filepath.Join(rootfs, "/sys"), "sysfs", 0, ""); err != nil {
+	// 挂载 sysfs 文件系统，只读挂载，避免容器修改主机的设备和内核设置
+	sysFlags := syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC
+	if err := mountKernelFilesystem("sysfs", filepath.Join(rootfs, "/sys"), sysFlags, userns); err != nil {
 		return fmt.Errorf("挂载 sys 失败: %v", err)
 	}
 
@@ -67,11 +443,64 @@ func setupContainerMounts(rootfs string) error {
 		return fmt.Errorf("挂载 dev/pts 失败: %v", err)
 	}
 
-	// 创建一些基本设备节点
-	devNull := filepath.Join(rootfs, "/dev/null")
-	if err := unix.Mknod(devNull, unix.S_IFCHR|0666, int(unix.Mkdev(1, 3))); err != nil && !os.IsExist(err) {
-		return fmt.Errorf("创建 /dev/null 失败: %v", err)
+	// 挂载 /dev/shm，用于POSIX共享内存
+	shmDir := filepath.Join(rootfs, "/dev/shm")
+	if err := os.MkdirAll(shmDir, 01777); err != nil {
+		return fmt.Errorf("创建 /dev/shm 目录失败: %v", err)
+	}
+	if err := mountFilesystem(shmSource, shmDir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("挂载 dev/shm 失败: %v", err)
+	}
+
+	// 创建标准设备节点
+	for _, device := range defaultDevices {
+		if err := createDevice(filepath.Join(rootfs, device.ContainerPath), device, userns); err != nil {
+			return fmt.Errorf("创建 %s 失败: %v", device.ContainerPath, err)
+		}
 	}
 
+	// /dev/ptmx 指向容器自己的devpts实例，/dev/fd 等指向 /proc/self/fd
+	for _, link := range defaultDeviceLinks {
+		if err := os.Symlink(link[1], filepath.Join(rootfs, link[0])); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("创建 %s 失败: %v", link[0], err)
+		}
+	}
+
+	return nil
+}
+
+// mountKernelFilesystem 挂载proc或sysfs
+// 在user namespace中只能挂载属于该user namespace的pid和net namespace对应的proc和sysfs，
+// 与主机共享pid或网络时挂载会返回EPERM，这时改为递归绑定挂载主机上的 /proc 或 /sys
+func mountKernelFilesystem(fstype, target string, flags int, userns bool) error {
+	err := mountFilesystem(fstype, target, fstype, flags, "")
+	if err == nil || !userns || !errors.Is(err, syscall.EPERM) {
+		return err
+	}
+
+	source := "/" + filepath.Base(target)
+	if err := mountFilesystem(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return err
+	}
+	if flags&syscall.MS_RDONLY != 0 {
+		return remountReadonly(target)
+	}
+	return nil
+}
+
+// setupDevices 在容器中创建 --device 指定的主机设备
+func setupDevices(rootfs string, devices []DeviceMapping, userns bool) error {
+	for _, device := range devices {
+		path, err := resolveInRootfs(rootfs, device.ContainerPath)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := createDevice(path, device, userns); err != nil {
+			return fmt.Errorf("创建 %s 失败: %v", device.ContainerPath, err)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,44 @@
+//go:build linux
+// +build linux
+
+package container
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseTmpfsOptions(t *testing.T) {
+	const defaults = syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
+
+	tests := []struct {
+		options   string
+		wantFlags int
+		wantData  string
+	}{
+		{"", defaults, ""},
+		{"ro", defaults | syscall.MS_RDONLY, ""},
+		{"rw", defaults, ""},
+		{"ro,rw", defaults, ""},
+		{"rw,ro", defaults | syscall.MS_RDONLY, ""},
+		{"exec", syscall.MS_NOSUID | syscall.MS_NODEV, ""},
+		{"exec,suid,dev", 0, ""},
+		{"exec,noexec", defaults, ""},
+		{"size=64m", defaults, "size=64m"},
+		{"ro,size=64m,exec,mode=1777", syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV, "size=64m,mode=1777"},
+		{",size=1m,,nr_inodes=10,", defaults, "size=1m,nr_inodes=10"},
+		{"uid=1000,gid=1000", defaults, "uid=1000,gid=1000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.options, func(t *testing.T) {
+			flags, data := parseTmpfsOptions(tt.options)
+			if flags != tt.wantFlags {
+				t.Errorf("parseTmpfsOptions(%q) flags = %#x, want %#x", tt.options, flags, tt.wantFlags)
+			}
+			if data != tt.wantData {
+				t.Errorf("parseTmpfsOptions(%q) data = %q, want %q", tt.options, data, tt.wantData)
+			}
+		})
+	}
+}
@@ -4,8 +4,10 @@
 package container
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"syscall"
 )
@@ -23,13 +25,88 @@ func mountFilesystem(source, target, fstype string, flags int, data string) erro
 }
 
 // setNamespaceFlags 设置namespace隔离标志（非Linux平台的模拟实现）
-func setNamespaceFlags(attr *syscall.SysProcAttr) {
+func setNamespaceFlags(attr *syscall.SysProcAttr, config *Config) {
 	// 在非Linux平台上不做任何操作
 	fmt.Println("模拟设置namespace隔离（在非Linux平台上不可用）")
 }
 
+// setPodNamespaceFlags 设置pod infra进程的namespace隔离标志（非Linux平台的模拟实现）
+func setPodNamespaceFlags(attr *syscall.SysProcAttr, netMode string) {
+	fmt.Println("模拟设置namespace隔离（在非Linux平台上不可用）")
+}
+
+// startInNamespaces 在其他容器的namespace中启动进程（非Linux平台不支持）
+func startInNamespaces(cmd *exec.Cmd, namespaces map[string]string) error {
+	if len(namespaces) > 0 {
+		return fmt.Errorf("当前平台不支持加入其他容器的namespace")
+	}
+	return cmd.Start()
+}
+
+// enterCgroupNamespace 创建cgroup namespace（非Linux平台的模拟实现）
+func enterCgroupNamespace() error {
+	return nil
+}
+
+// prepareRoot 准备根目录（非Linux平台的模拟实现）
+func prepareRoot(rootfs string, volumes []VolumeMapping) error {
+	fmt.Printf("模拟设置根目录挂载: %s\n", rootfs)
+	return nil
+}
+
+// pivotRoot 切换根目录（非Linux平台使用chroot模拟）
+func pivotRoot(rootfs string) error {
+	return syscall.Chroot(rootfs)
+}
+
+// setupVolumes 挂载数据卷（非Linux平台的模拟实现）
+func setupVolumes(rootfs string, volumes []VolumeMapping) error {
+	for _, volume := range volumes {
+		mountFilesystem(volume.HostPath, filepath.Join(rootfs, volume.ContainerPath), "", 0, "")
+	}
+	return nil
+}
+
+// setupTmpfs 挂载tmpfs（非Linux平台的模拟实现）
+func setupTmpfs(rootfs string, mounts []TmpfsMount) error {
+	for _, mount := range mounts {
+		mountFilesystem("tmpfs", filepath.Join(rootfs, mount.Path), "tmpfs", 0, mount.Options)
+	}
+	return nil
+}
+
+// setupDevices 创建映射的主机设备（非Linux平台的模拟实现）
+func setupDevices(rootfs string, devices []DeviceMapping, userns bool) error {
+	for _, device := range devices {
+		fmt.Printf("模拟创建设备节点: %s\n", filepath.Join(rootfs, device.ContainerPath))
+	}
+	return nil
+}
+
+// maskPaths 屏蔽内核路径（非Linux平台的模拟实现）
+func maskPaths(paths []string) error {
+	for _, path := range paths {
+		fmt.Printf("模拟屏蔽路径: %s\n", path)
+	}
+	return nil
+}
+
+// readonlyPaths 设置只读的内核路径（非Linux平台的模拟实现）
+func readonlyPaths(paths []string) error {
+	for _, path := range paths {
+		fmt.Printf("模拟只读挂载: %s\n", path)
+	}
+	return nil
+}
+
+// remountReadonly 重新挂载为只读（非Linux平台的模拟实现）
+func remountReadonly(path string) error {
+	fmt.Printf("模拟只读挂载: %s\n", path)
+	return nil
+}
+
 // setupContainerMounts 设置容器的挂载点（非Linux平台的模拟实现）
-func setupContainerMounts(rootfs string) error {
+func setupContainerMounts(rootfs string, userns bool, shmSource string) error {
 	// 创建挂载点目录
 	for _, dir := range []string{"/proc", "/sys", "/dev", "/dev/pts", "/tmp"} {
 		path := filepath.Join(rootfs, dir)
@@ -53,9 +130,18 @@ func setupContainerMounts(rootfs string) error {
 	// 挂载 devpts
 	mountFilesystem("devpts", filepath.Join(rootfs, "/dev/pts"), "devpts", 0, "")
 
-	// 创建一些基本设备节点
-	devNull := filepath.Join(rootfs, "/dev/null")
-	fmt.Printf("模拟创建设备节点: %s\n", devNull)
+	// 挂载 /dev/shm
+	mountFilesystem(shmSource, filepath.Join(rootfs, "/dev/shm"), "", 0, "")
+
+	// 创建标准设备节点
+	for _, device := range defaultDevices {
+		fmt.Printf("模拟创建设备节点: %s\n", filepath.Join(rootfs, device.ContainerPath))
+	}
 
 	return nil
 }
+
+// openUserns 创建user namespace（非Linux平台不支持）
+func openUserns(uidMap, gidMap []IDMapping) (*os.File, error) {
+	return nil, errors.New("当前平台不支持user namespace")
+}
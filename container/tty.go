@@ -0,0 +1,159 @@
+package container
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// newConsoleSocket 创建用于传递终端主设备的socket对
+// parent 留在监控进程中接收，child 交给容器的init进程
+func newConsoleSocket() (parent *os.File, child *os.File, err error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("创建终端socket失败: %v", err)
+	}
+	return os.NewFile(uintptr(fds[0]), "console-parent"), os.NewFile(uintptr(fds[1]), "console-child"), nil
+}
+
+// setupConsole 在容器内分配伪终端并作为init进程的控制终端
+// 伪终端从容器自己的 /dev/pts 实例中分配，主设备通过socket发送给监控进程，
+// 从设备替换init进程的标准输入输出，需要在切换根目录之后调用
+func setupConsole(socketFd int) error {
+	socket := os.NewFile(uintptr(socketFd), "console-socket")
+	if socket == nil {
+		return fmt.Errorf("无法打开终端socket")
+	}
+	defer socket.Close()
+
+	master, err := os.OpenFile("/dev/pts/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return fmt.Errorf("打开 /dev/pts/ptmx 失败: %v", err)
+	}
+	defer master.Close()
+
+	// 解锁从设备并获取编号
+	if err := unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		return fmt.Errorf("解锁伪终端失败: %v", err)
+	}
+	ptn, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		return fmt.Errorf("获取伪终端编号失败: %v", err)
+	}
+
+	slavePath := "/dev/pts/" + strconv.Itoa(ptn)
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return fmt.Errorf("打开 %s 失败: %v", slavePath, err)
+	}
+	defer slave.Close()
+
+	// 把主设备交给监控进程
+	rights := unix.UnixRights(int(master.Fd()))
+	if err := unix.Sendmsg(int(socket.Fd()), []byte(slavePath), rights, nil, 0); err != nil {
+		return fmt.Errorf("发送终端失败: %v", err)
+	}
+
+	// 创建新会话并把从设备设为控制终端，这样容器内才有作业控制
+	if _, err := unix.Setsid(); err != nil {
+		return fmt.Errorf("创建会话失败: %v", err)
+	}
+	if err := unix.IoctlSetInt(int(slave.Fd()), unix.TIOCSCTTY, 0); err != nil {
+		return fmt.Errorf("设置控制终端失败: %v", err)
+	}
+
+	for i := 0; i <= 2; i++ {
+		if err := unix.Dup3(int(slave.Fd()), i, 0); err != nil {
+			return fmt.Errorf("重定向标准输入输出失败: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// receiveConsole 接收容器init进程发来的终端主设备
+func receiveConsole(socket *os.File) (*os.File, error) {
+	buf := make([]byte, 64)
+	oob := make([]byte, unix.CmsgSpace(4))
+
+	n, oobn, _, _, err := unix.Recvmsg(int(socket.Fd()), buf, oob, 0)
+	if err != nil {
+		return nil, fmt.Errorf("接收容器终端失败: %v", err)
+	}
+	if n == 0 && oobn == 0 {
+		return nil, fmt.Errorf("容器进程未创建终端")
+	}
+
+	messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(messages) != 1 {
+		return nil, fmt.Errorf("解析容器终端失败: %v", err)
+	}
+	fds, err := unix.ParseUnixRights(&messages[0])
+	if err != nil || len(fds) != 1 {
+		return nil, fmt.Errorf("解析容器终端失败: %v", err)
+	}
+
+	return os.NewFile(uintptr(fds[0]), string(buf[:n])), nil
+}
+
+// isTerminal 判断文件描述符是否为终端
+func isTerminal(fd int) bool {
+	_, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	return err == nil
+}
+
+// makeRaw 把终端设置为raw模式，返回原来的设置用于恢复
+// 按键原样发送给容器，由容器内的终端处理回显、行编辑和中断信号
+func makeRaw(fd int) (*unix.Termios, error) {
+	oldState, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, fmt.Errorf("读取终端设置失败: %v", err)
+	}
+
+	state := *oldState
+	state.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	state.Oflag &^= unix.OPOST
+	state.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	state.Cflag &^= unix.CSIZE | unix.PARENB
+	state.Cflag |= unix.CS8
+	state.Cc[unix.VMIN] = 1
+	state.Cc[unix.VTIME] = 0
+
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &state); err != nil {
+		return nil, fmt.Errorf("设置终端raw模式失败: %v", err)
+	}
+	return oldState, nil
+}
+
+// restoreTerminal 恢复终端设置
+func restoreTerminal(fd int, state *unix.Termios) {
+	unix.IoctlSetTermios(fd, unix.TCSETS, state)
+}
+
+// encodeWinsize 把终端窗口大小编码为resize帧的数据：行数和列数各2字节
+func encodeWinsize(fd int) ([]byte, error) {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint16(data[0:], ws.Row)
+	binary.BigEndian.PutUint16(data[2:], ws.Col)
+	return data, nil
+}
+
+// resizeConsole 按resize帧的数据设置终端窗口大小
+// 内核会向终端的前台进程组发送SIGWINCH
+func resizeConsole(console *os.File, data []byte) error {
+	if len(data) != 4 {
+		return fmt.Errorf("无效的窗口大小")
+	}
+	ws := &unix.Winsize{
+		Row: binary.BigEndian.Uint16(data[0:]),
+		Col: binary.BigEndian.Uint16(data[2:]),
+	}
+	return unix.IoctlSetWinsize(int(console.Fd()), unix.TIOCSWINSZ, ws)
+}
@@ -0,0 +1,114 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ExecUser 容器内进程的用户信息
+type ExecUser struct {
+	Uid  uint32 // 用户ID
+	Gid  uint32 // 组ID
+	Home string // 用户主目录
+}
+
+// lookupUser 在当前根目录的 /etc/passwd 和 /etc/group 中解析用户
+// 支持 "user"、"uid"、"user:group"、"uid:gid" 等格式，为空时返回root
+func lookupUser(spec string) (*ExecUser, error) {
+	user := &ExecUser{Uid: 0, Gid: 0, Home: "/root"}
+	if spec == "" {
+		return user, nil
+	}
+
+	userPart, groupPart := spec, ""
+	if idx := strings.Index(spec, ":"); idx >= 0 {
+		userPart, groupPart = spec[:idx], spec[idx+1:]
+	}
+
+	// 查找用户，数字形式的UID允许不在passwd中
+	entry, err := findPasswdEntry("/etc/passwd", userPart)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case entry != nil:
+		user.Uid = parseID(entry[2])
+		user.Gid = parseID(entry[3])
+		user.Home = entry[5]
+	case isNumeric(userPart):
+		user.Uid = parseID(userPart)
+		user.Home = "/"
+	default:
+		return nil, fmt.Errorf("容器中不存在用户 %s", userPart)
+	}
+
+	if groupPart == "" {
+		return user, nil
+	}
+
+	// 查找用户组
+	entry, err = findPasswdEntry("/etc/group", groupPart)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case entry != nil:
+		user.Gid = parseID(entry[2])
+	case isNumeric(groupPart):
+		user.Gid = parseID(groupPart)
+	default:
+		return nil, fmt.Errorf("容器中不存在用户组 %s", groupPart)
+	}
+
+	return user, nil
+}
+
+// findPasswdEntry 在passwd格式的文件中按名称或ID查找条目
+// 文件不存在时返回nil，不视为错误
+func findPasswdEntry(path, nameOrID string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// passwd: name:password:uid:gid:gecos:home:shell
+		// group:  name:password:gid:members
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 {
+			continue
+		}
+		if fields[0] == nameOrID || fields[2] == nameOrID {
+			// 补齐字段，方便调用方按passwd格式读取
+			for len(fields) < 7 {
+				fields = append(fields, "")
+			}
+			return fields, nil
+		}
+	}
+
+	return nil, scanner.Err()
+}
+
+func isNumeric(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 32)
+	return err == nil
+}
+
+func parseID(s string) uint32 {
+	id, _ := strconv.ParseUint(s, 10, 32)
+	return uint32(id)
+}
@@ -0,0 +1,210 @@
+package container
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/akm/godocker/rootless"
+	"github.com/akm/godocker/storage"
+	"golang.org/x/sys/unix"
+)
+
+// IDMapping user namespace 中的UID或GID映射
+type IDMapping struct {
+	ContainerID int // 容器内的起始ID
+	HostID      int // 对应的主机起始ID
+	Size        int // 映射的ID数量
+}
+
+// resolveUserns 根据 --userns 从 /etc/subuid 和 /etc/subgid 读取映射，并检查映射是否可用
+func resolveUserns(config *Config) error {
+	// rootless模式下godocker本身已经运行在pause进程的user namespace中，所有可用的ID都已映射
+	if rootless.Enabled() && (config.Userns != "" || len(config.UidMap) > 0 || len(config.GidMap) > 0) {
+		return fmt.Errorf("rootless模式下不支持 --userns/--uidmap/--gidmap")
+	}
+
+	if config.Userns != "" {
+		if len(config.UidMap) > 0 || len(config.GidMap) > 0 {
+			return fmt.Errorf("--userns 不能与 --uidmap/--gidmap 同时使用")
+		}
+
+		uidMap, err := readSubordinateIds(rootless.SubuidPath, config.Userns)
+		if err != nil {
+			return err
+		}
+		gidMap, err := readSubordinateIds(rootless.SubgidPath, config.Userns)
+		if err != nil {
+			return err
+		}
+		config.UidMap = []IDMapping{uidMap}
+		config.GidMap = []IDMapping{gidMap}
+	}
+
+	// 只指定了UID映射时GID使用相同的映射
+	if len(config.UidMap) > 0 && len(config.GidMap) == 0 {
+		config.GidMap = append([]IDMapping{}, config.UidMap...)
+	}
+	if len(config.GidMap) > 0 && len(config.UidMap) == 0 {
+		return fmt.Errorf("指定 --gidmap 时必须同时指定 --uidmap")
+	}
+
+	// init进程需要以容器内的root身份设置挂载点和切换用户
+	if len(config.UidMap) > 0 {
+		if _, ok := mapToHost(config.UidMap, 0); !ok {
+			return fmt.Errorf("UID映射中必须包含容器内的root (0)")
+		}
+		if _, ok := mapToHost(config.GidMap, 0); !ok {
+			return fmt.Errorf("GID映射中必须包含容器内的root (0)")
+		}
+	}
+	return nil
+}
+
+// readSubordinateIds 读取用户的从属ID范围，映射为容器内从0开始的ID，name可以是用户名或UID
+func readSubordinateIds(path, name string) (IDMapping, error) {
+	start, count, err := rootless.ReadSubordinateRange(path, name)
+	if err != nil {
+		return IDMapping{}, err
+	}
+	return IDMapping{ContainerID: 0, HostID: start, Size: count}, nil
+}
+
+// ParseIDMapping 解析 --uidmap/--gidmap 参数，格式为 容器ID:主机ID:数量
+func ParseIDMapping(spec string) (IDMapping, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 {
+		return IDMapping{}, fmt.Errorf("无效的ID映射: %s，格式为 容器ID:主机ID:数量", spec)
+	}
+
+	var values [3]int
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 {
+			return IDMapping{}, fmt.Errorf("无效的ID映射: %s", spec)
+		}
+		values[i] = value
+	}
+	if values[2] == 0 {
+		return IDMapping{}, fmt.Errorf("ID映射的数量必须大于0: %s", spec)
+	}
+	return IDMapping{ContainerID: values[0], HostID: values[1], Size: values[2]}, nil
+}
+
+// mapToHost 把容器内的ID转换为主机上的ID
+func mapToHost(mappings []IDMapping, id int) (int, bool) {
+	for _, m := range mappings {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID, true
+		}
+	}
+	return 0, false
+}
+
+// usernsEnabled 容器是否在自己的user namespace中运行
+func (c *Config) usernsEnabled() bool {
+	return len(c.UidMap) > 0
+}
+
+// chownRootfs 把根文件系统中文件的属主转换为映射后的主机ID
+// 镜像中的文件属主是容器内的ID，在user namespace中只有映射后的主机ID才对应容器内的用户。
+// 只用于生成共享的镜像副本，不在容器层上调用，否则overlay驱动会把整个镜像复制到容器层
+func chownRootfs(rootfs string, uidMap, gidMap []IDMapping) error {
+	return filepath.Walk(rootfs, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		stat := info.Sys().(*syscall.Stat_t)
+		uid, uidOk := mapToHost(uidMap, int(stat.Uid))
+		gid, gidOk := mapToHost(gidMap, int(stat.Gid))
+		if !uidOk {
+			uid = int(stat.Uid)
+		}
+		if !gidOk {
+			gid = int(stat.Gid)
+		}
+		if uid == int(stat.Uid) && gid == int(stat.Gid) {
+			return nil
+		}
+
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return fmt.Errorf("修改 %s 的属主失败: %v", path, err)
+		}
+		// chown会清除setuid等特殊权限位，重新设置一次
+		if info.Mode()&os.ModeSymlink == 0 {
+			return unix.Chmod(path, stat.Mode&07777)
+		}
+		return nil
+	})
+}
+
+// mappedImageLayer 返回按ID映射修改过属主的镜像副本层，不存在时从镜像最上层生成
+// 用于不支持idmapped mount的情况。副本把镜像的所有层合并为一层，
+// 使用相同镜像和相同映射的容器共用同一个副本，只在第一次创建时修改属主
+func mappedImageLayer(driver storage.Driver, top string, uidMap, gidMap []IDMapping) (string, error) {
+	hash := sha256.Sum256([]byte(fmt.Sprint(uidMap, gidMap)))
+	id := top + "-" + hex.EncodeToString(hash[:])[:12]
+
+	dir := filepath.Join(storage.DefaultStorageRoot, "idmap")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("创建目录失败: %v", err)
+	}
+	unlock, err := lockFile(filepath.Join(dir, "idmap.lock"))
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// 副本生成完成后才写入标记文件，中途失败留下的层在下次生成前删除
+	marker := filepath.Join(dir, id)
+	if _, err := os.Stat(marker); err == nil {
+		return id, nil
+	}
+	driver.Remove(id)
+
+	if err := createMappedLayer(driver, id, top, uidMap, gidMap); err != nil {
+		driver.Remove(id)
+		return "", fmt.Errorf("生成镜像副本失败: %v", err)
+	}
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		driver.Remove(id)
+		return "", err
+	}
+	return id, nil
+}
+
+func createMappedLayer(driver storage.Driver, id, top string, uidMap, gidMap []IDMapping) error {
+	if err := driver.Create(id, ""); err != nil {
+		return err
+	}
+	diff, err := driver.Diff(id)
+	if err != nil {
+		return err
+	}
+
+	src, err := driver.Mount(top)
+	if err != nil {
+		return err
+	}
+	err = storage.CopyDir(src, diff)
+	driver.Unmount(top)
+	if err != nil {
+		return err
+	}
+	return chownRootfs(diff, uidMap, gidMap)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
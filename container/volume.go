@@ -0,0 +1,154 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/akm/godocker/volume"
+)
+
+// resolveVolumes 把容器配置中的命名数据卷解析为主机上的目录
+// 数据卷不存在时自动创建；数据卷为空时用镜像中挂载点位置的内容填充
+func resolveVolumes(container *ContainerInfo) error {
+	for i := range container.Config.Volumes {
+		mapping := &container.Config.Volumes[i]
+		if mapping.Name == "" {
+			// 主机路径不存在时创建为目录，user namespace中的init进程没有权限在主机上创建
+			if _, err := os.Stat(mapping.HostPath); os.IsNotExist(err) {
+				if err := os.MkdirAll(mapping.HostPath, 0755); err != nil {
+					return fmt.Errorf("创建主机目录 %s 失败: %v", mapping.HostPath, err)
+				}
+			}
+			continue
+		}
+
+		vol, err := volume.GetOrCreate(mapping.Name)
+		if err != nil {
+			return fmt.Errorf("获取数据卷 %s 失败: %v", mapping.Name, err)
+		}
+		if err := mountVolume(container, vol); err != nil {
+			return err
+		}
+		mapping.HostPath = vol.Mountpoint
+
+		src, err := resolveInRootfs(container.Rootfs, mapping.ContainerPath)
+		if err != nil {
+			return err
+		}
+		if err := volume.Populate(vol, src); err != nil {
+			return err
+		}
+
+		// 新的空数据卷交给容器内的属主，否则user namespace中的容器无法写入
+		if container.Config.usernsEnabled() {
+			uid, gid := vol.Owner()
+			if err := chownVolumeRoot(vol.Mountpoint, uid, gid, container.Config.UidMap, container.Config.GidMap); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// chownVolumeRoot 数据卷目录的属主仍是未经映射的uid和gid时，改为它们映射后的主机ID
+// 通常是容器内的root；tmpfs数据卷可以通过 o=uid=...,gid=... 指定其他用户
+func chownVolumeRoot(dir string, uid, gid int, uidMap, gidMap []IDMapping) error {
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		return err
+	}
+	if int(st.Uid) != uid || int(st.Gid) != gid {
+		return nil
+	}
+
+	hostUid, _ := mapToHost(uidMap, uid)
+	hostGid, _ := mapToHost(gidMap, gid)
+	if err := os.Chown(dir, hostUid, hostGid); err != nil {
+		return fmt.Errorf("修改数据卷目录的属主失败: %v", err)
+	}
+	return nil
+}
+
+// mountVolumes 确保容器使用的命名数据卷已经挂载
+func mountVolumes(container *ContainerInfo) error {
+	for _, mapping := range container.Config.Volumes {
+		if mapping.Name == "" {
+			continue
+		}
+		vol, err := volume.Get(mapping.Name)
+		if err != nil {
+			return err
+		}
+		if err := mountVolume(container, vol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mountVolume 挂载数据卷，容器使用user namespace时tmpfs的属主转换为主机上的ID，
+// 这样容器内的root（或 o=uid=...,gid=... 指定的用户）才能写入
+func mountVolume(container *ContainerInfo, vol *volume.Volume) error {
+	uid, gid := vol.Owner()
+	if container.Config.usernsEnabled() {
+		uid, _ = mapToHost(container.Config.UidMap, uid)
+		gid, _ = mapToHost(container.Config.GidMap, gid)
+	}
+	return volume.Mount(vol, uid, gid)
+}
+
+// volumeUsers 返回使用指定数据卷的容器名称
+func volumeUsers(name string) ([]string, error) {
+	containers, err := loadAllContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	var users []string
+	for _, c := range containers {
+		for _, mapping := range c.Config.Volumes {
+			if mapping.Name == name {
+				users = append(users, c.Name)
+				break
+			}
+		}
+	}
+	return users, nil
+}
+
+// RemoveVolume 删除数据卷，仍被容器使用（包括已停止的容器）时拒绝删除
+func RemoveVolume(name string) error {
+	users, err := volumeUsers(name)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return fmt.Errorf("%w: %s %v", volume.ErrVolumeInUse, name, users)
+	}
+	return volume.Remove(name)
+}
+
+// PruneVolumes 删除所有没有被容器使用的数据卷，返回被删除的数据卷名称
+func PruneVolumes() ([]string, error) {
+	volumes, err := volume.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, vol := range volumes {
+		users, err := volumeUsers(vol.Name)
+		if err != nil {
+			return removed, err
+		}
+		if len(users) > 0 {
+			continue
+		}
+		if err := volume.Remove(vol.Name); err != nil {
+			return removed, err
+		}
+		removed = append(removed, vol.Name)
+	}
+	return removed, nil
+}
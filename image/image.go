@@ -2,11 +2,15 @@ package image
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/akm/godocker/config"
+	"github.com/akm/godocker/storage"
 )
 
 // ImageInfo 镜像信息
@@ -16,13 +20,16 @@ type ImageInfo struct {
 	Tag        string    // 标签
 	Size       int64     // 大小（字节）
 	CreatedAt  time.Time // 创建时间
-	Layers     []string  // 层ID列表
+	Layers     []string  // 层ID列表，从最底层开始排列
+
+	StorageDriver string // 保存镜像层的存储驱动
 }
 
-const (
-	// 镜像存储根目录
-	DefaultImageRoot = "/var/lib/godocker/images"
-)
+// 镜像存储根目录
+var DefaultImageRoot = filepath.Join(config.DataRoot(), "images")
+
+// ErrImageNotFound 本地不存在指定的镜像
+var ErrImageNotFound = errors.New("找不到镜像")
 
 // PullImage 拉取镜像
 func PullImage(imageName string) error {
@@ -40,22 +47,39 @@ func PullImage(imageName string) error {
 		return fmt.Errorf("创建镜像目录失败: %v", err)
 	}
 
+	// 镜像层由存储驱动保存
+	driver, err := storage.DefaultDriver()
+	if err != nil {
+		return err
+	}
+
 	// 在实际实现中，这里应该使用Docker Registry API拉取镜像
 	// 简化示例使用 tar 命令模拟拉取过程
 	// 这部分简化处理，实际拉取需要实现Docker Registry HTTP API交互
-	if err := simulatePullImage(repository, tag, imageRoot); err != nil {
+	imageId := generateImageId(repository, tag)
+	layerId := imageId // 简化处理，实际应该有多层
+	if err := driver.Create(layerId, ""); err != nil {
+		return fmt.Errorf("创建镜像层失败: %v", err)
+	}
+	layerDir, err := driver.Diff(layerId)
+	if err != nil {
+		driver.Remove(layerId)
+		return err
+	}
+	if err := simulatePullImage(repository, tag, layerDir); err != nil {
+		driver.Remove(layerId)
 		return err
 	}
 
 	// 创建镜像元数据
-	imageId := generateImageId(repository, tag)
 	imageInfo := &ImageInfo{
-		ID:         imageId,
-		Repository: repository,
-		Tag:        tag,
-		Size:       calculateImageSize(imageRoot),
-		CreatedAt:  time.Now(),
-		Layers:     []string{imageId}, // 简化处理，实际应该有多层
+		ID:            imageId,
+		Repository:    repository,
+		Tag:           tag,
+		Size:          calculateImageSize(layerDir),
+		CreatedAt:     time.Now(),
+		Layers:        []string{layerId},
+		StorageDriver: driver.Name(),
 	}
 
 	// 保存镜像元数据
@@ -113,6 +137,42 @@ func ListImages() ([]*ImageInfo, error) {
 	return images, nil
 }
 
+// FindImage 按 "仓库:标签"、仓库名（默认latest标签）或镜像ID前缀查找本地镜像
+func FindImage(ref string) (*ImageInfo, error) {
+	if _, err := os.Stat(DefaultImageRoot); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotFound, ref)
+	}
+
+	images, err := ListImages()
+	if err != nil {
+		return nil, err
+	}
+
+	repository, tag := parseImageName(ref)
+	if tag == "" {
+		tag = "latest"
+	}
+
+	var matched []*ImageInfo
+	for _, img := range images {
+		if img.Repository == repository && img.Tag == tag {
+			return img, nil
+		}
+		if strings.HasPrefix(img.ID, ref) {
+			matched = append(matched, img)
+		}
+	}
+
+	switch len(matched) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", ErrImageNotFound, ref)
+	case 1:
+		return matched[0], nil
+	default:
+		return nil, fmt.Errorf("镜像ID前缀 %s 匹配到多个镜像", ref)
+	}
+}
+
 // GetImagePath 获取镜像文件系统路径
 func GetImagePath(imageName string) (string, error) {
 	repository, tag := parseImageName(imageName)
@@ -130,6 +190,141 @@ func GetImagePath(imageName string) (string, error) {
 	return imageRoot, nil
 }
 
+// GetImage 按 "仓库:标签" 读取镜像信息，未指定标签时使用latest
+func GetImage(imageName string) (*ImageInfo, error) {
+	imageRoot, err := GetImagePath(imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := readImageMetadata(imageRoot)
+	if err != nil {
+		return nil, err
+	}
+	if info.StorageDriver == "" || len(info.Layers) == 0 {
+		if err := migrateImage(imageRoot, info); err != nil {
+			return nil, fmt.Errorf("迁移镜像 %s 的旧存储格式失败: %v", imageName, err)
+		}
+	}
+	return info, nil
+}
+
+// migrateImage 把旧版本拉取的镜像移入存储驱动，第一次使用镜像时调用
+// 旧版本把每层保存在镜像目录的 layers/<层ID> 下，更早的版本只有一个 rootfs 目录，作为唯一的一层
+func migrateImage(imageRoot string, info *ImageInfo) error {
+	driver, err := storage.DefaultDriver()
+	if err != nil {
+		return err
+	}
+
+	type oldLayer struct{ id, dir string }
+	var layers []oldLayer
+	for _, id := range info.Layers {
+		dir := filepath.Join(imageRoot, "layers", id)
+		if stat, err := os.Stat(dir); err == nil && stat.IsDir() {
+			layers = append(layers, oldLayer{id, dir})
+		}
+	}
+	if len(layers) == 0 {
+		dir := filepath.Join(imageRoot, "rootfs")
+		if _, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("没有可用的文件系统层")
+		}
+		id := info.ID
+		if id == "" {
+			id = generateImageId(info.Repository, info.Tag)
+		}
+		layers = append(layers, oldLayer{id, dir})
+	}
+
+	// 从最底层开始创建，每层的父层是它下面的一层
+	// 失败时把已经移动的层移回原处并删除创建的层，下次使用镜像时重新迁移
+	var ids []string
+	var moved []oldLayer
+	rollback := func() {
+		for _, layer := range moved {
+			if diff, err := driver.Diff(layer.id); err == nil {
+				os.Rename(diff, layer.dir)
+			}
+		}
+		for _, id := range ids {
+			driver.Remove(id)
+		}
+	}
+
+	parent := ""
+	for _, layer := range layers {
+		if err := driver.Create(layer.id, parent); err != nil {
+			rollback()
+			return fmt.Errorf("创建镜像层失败: %v", err)
+		}
+		ids = append(ids, layer.id)
+
+		diff, err := driver.Diff(layer.id)
+		if err != nil {
+			rollback()
+			return err
+		}
+		// 层目录与存储驱动在同一个数据目录下，直接移动；
+		// vfs驱动的层中已经复制了父层的内容，只能把本层复制进去
+		if err := os.Remove(diff); err == nil {
+			if err := os.Rename(layer.dir, diff); err != nil {
+				rollback()
+				return fmt.Errorf("移动镜像层 %s 失败: %v", layer.id, err)
+			}
+			moved = append(moved, layer)
+		} else if err := storage.CopyDir(layer.dir, diff); err != nil {
+			rollback()
+			return fmt.Errorf("复制镜像层 %s 失败: %v", layer.id, err)
+		}
+		parent = layer.id
+	}
+
+	info.Layers = ids
+	info.StorageDriver = driver.Name()
+	if err := writeImageMetadata(imageRoot, info); err != nil {
+		rollback()
+		return err
+	}
+
+	os.RemoveAll(filepath.Join(imageRoot, "layers"))
+	os.RemoveAll(filepath.Join(imageRoot, "rootfs"))
+	return nil
+}
+
+// 保存镜像元数据，先写临时文件再重命名，避免读到不完整的内容
+func writeImageMetadata(imageRoot string, info *ImageInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("序列化镜像元数据失败: %v", err)
+	}
+
+	path := filepath.Join(imageRoot, "metadata.json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("保存镜像元数据失败: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("保存镜像元数据失败: %v", err)
+	}
+	return nil
+}
+
+// 读取镜像元数据
+func readImageMetadata(imageRoot string) (*ImageInfo, error) {
+	data, err := os.ReadFile(filepath.Join(imageRoot, "metadata.json"))
+	if err != nil {
+		return nil, fmt.Errorf("读取镜像元数据失败: %v", err)
+	}
+
+	var info ImageInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("解析镜像元数据失败: %v", err)
+	}
+	return &info, nil
+}
+
 // 解析镜像名称
 func parseImageName(imageName string) (string, string) {
 	parts := strings.Split(imageName, ":")
@@ -164,9 +359,8 @@ func calculateImageSize(imageRoot string) int64 {
 }
 
 // 模拟拉取镜像（实际实现中应使用Docker Registry API）
-func simulatePullImage(repository, tag, imageRoot string) error {
-	// 创建示例rootfs
-	rootfsDir := filepath.Join(imageRoot, "rootfs")
+func simulatePullImage(repository, tag, rootfsDir string) error {
+	// 创建示例rootfs，作为镜像唯一的一层
 	if err := os.MkdirAll(rootfsDir, 0755); err != nil {
 		return fmt.Errorf("创建rootfs目录失败: %v", err)
 	}
@@ -184,12 +378,6 @@ func simulatePullImage(repository, tag, imageRoot string) error {
 		return fmt.Errorf("创建示例文件失败: %v", err)
 	}
 
-	// 模拟层信息
-	layersDir := filepath.Join(imageRoot, "layers")
-	if err := os.MkdirAll(layersDir, 0755); err != nil {
-		return fmt.Errorf("创建层目录失败: %v", err)
-	}
-
 	// 模拟下载进度
 	for i := 1; i <= 5; i++ {
 		fmt.Printf("拉取镜像层 %d/5: %d%%\n", i, i*20)
@@ -7,6 +7,7 @@ import (
 
 	"github.com/akm/godocker/cmd"
 	"github.com/akm/godocker/container"
+	"github.com/akm/godocker/rootless"
 )
 
 func main() {
@@ -20,15 +21,60 @@ func main() {
 		return
 	}
 
+	// 特殊处理shim命令，由启动容器的godocker进程创建，负责看护容器进程
+	if len(args) > 1 && args[0] == "shim" {
+		runShim(args[1:])
+		return
+	}
+
+	// 特殊处理podinfra命令，由启动pod的godocker进程创建，持有pod的namespace
+	if len(args) > 1 && args[0] == "podinfra" {
+		runPodInfra(args[1:])
+		return
+	}
+
+	// 特殊处理nsexec命令，由exec命令启动，此时已经加入了容器的namespace
+	if len(args) > 0 && args[0] == "nsexec" {
+		runExecProcess()
+		return
+	}
+
+	// 特殊处理rootless模式的pause进程，由普通用户第一次运行godocker时创建，持有共用的namespace
+	if len(args) > 0 && args[0] == rootless.PauseCommand {
+		rootless.Pause()
+		return
+	}
+
 	if len(args) < 1 {
 		printUsage()
 		os.Exit(1)
 	}
 
+	// 普通用户运行时在pause进程的user namespace和mount namespace中重新执行，成为namespace中的root
+	if os.Geteuid() != 0 && needsRootlessNamespace(args[0]) {
+		os.Exit(rootless.Reexec())
+	}
+
 	// 处理其他命令
 	switch args[0] {
 	case "run":
 		cmd.Run(args[1:])
+	case "create":
+		cmd.Create(args[1:])
+	case "start":
+		cmd.Start(args[1:])
+	case "attach":
+		cmd.Attach(args[1:])
+	case "exec":
+		cmd.Exec(args[1:])
+	case "logs":
+		cmd.Logs(args[1:])
+	case "inspect":
+		cmd.Inspect(args[1:])
+	case "volume":
+		cmd.Volume(args[1:])
+	case "pod":
+		cmd.Pod(args[1:])
 	case "ps":
 		cmd.Ps()
 	case "images":
@@ -46,11 +92,7 @@ func main() {
 		}
 		cmd.Stop(args[1])
 	case "rm":
-		if len(args) < 2 {
-			fmt.Println("请指定要删除的容器ID，例如: godocker rm [container-id]")
-			os.Exit(1)
-		}
-		cmd.Remove(args[1])
+		cmd.Remove(args[1:])
 	default:
 		fmt.Printf("未知命令: %s\n", args[0])
 		printUsage()
@@ -58,6 +100,16 @@ func main() {
 	}
 }
 
+// needsRootlessNamespace rootless模式下命令是否需要在pause进程的namespace中执行
+// exec需要从主机直接加入容器所在的user namespace；只读取数据的命令不需要挂载，直接在主机上执行
+func needsRootlessNamespace(command string) bool {
+	switch command {
+	case "exec", "ps", "images", "logs", "inspect":
+		return false
+	}
+	return true
+}
+
 // runInit 在容器内部执行初始化
 func runInit() {
 	if err := container.InitContainer(); err != nil {
@@ -66,17 +118,49 @@ func runInit() {
 	}
 }
 
+// runShim 运行容器监控进程
+func runShim(args []string) {
+	if err := container.RunShim(args); err != nil {
+		fmt.Fprintf(os.Stderr, "容器监控进程失败: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runPodInfra 运行pod的infra进程
+func runPodInfra(args []string) {
+	if err := container.RunPodInfra(args); err != nil {
+		fmt.Fprintf(os.Stderr, "pod infra进程失败: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runExecProcess 在容器的namespace中执行exec命令
+func runExecProcess() {
+	if err := container.RunExecProcess(); err != nil {
+		fmt.Printf("exec失败: %v\n", err)
+		os.Exit(1)
+	}
+}
+
 func printUsage() {
 	fmt.Println("GoDocker - 用于学习的简易Docker实现")
 	fmt.Println("\n用法:")
 	fmt.Println("  godocker [命令] [参数]")
 	fmt.Println("\n可用命令:")
 	fmt.Println("  run      运行一个容器")
+	fmt.Println("  create   创建容器但不启动")
+	fmt.Println("  start    启动已创建的容器 (-a 连接容器输出并等待结束)")
+	fmt.Println("  attach   连接到运行中的容器 (默认 Ctrl-P Ctrl-Q 分离)")
+	fmt.Println("  exec     在运行中的容器内执行命令")
+	fmt.Println("  logs     查看容器日志")
+	fmt.Println("  inspect  以JSON格式查看容器、镜像、网络、数据卷或pod的详细信息 (--format 指定模板)")
+	fmt.Println("  volume   管理数据卷 (create, ls, inspect, rm, prune)")
+	fmt.Println("  pod      管理pod (create, ls, inspect, start, stop, rm)")
 	fmt.Println("  ps       列出正在运行的容器")
 	fmt.Println("  images   列出本地镜像")
 	fmt.Println("  pull     拉取镜像")
 	fmt.Println("  stop     停止容器")
-	fmt.Println("  rm       删除容器")
+	fmt.Println("  rm       删除容器 (-f 强制删除运行中的容器)")
 	fmt.Println("\n示例:")
 	fmt.Println("  godocker run -it ubuntu:latest /bin/bash")
 }
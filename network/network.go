@@ -1,6 +1,7 @@
 package network
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -8,23 +9,41 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/akm/godocker/config"
+	"github.com/akm/godocker/rootless"
 )
 
 // NetworkConfig 网络配置
 type NetworkConfig struct {
-	Mode      string // 网络模式：bridge, host, none
+	Mode      string // 网络模式：bridge, host, none 或 container:<ID>
 	IPAddress string // 容器IP地址
 	Gateway   string // 网关地址
 	Subnet    string // 子网掩码
 	MacAddr   string // MAC地址
 }
 
+// NetworkInfo 网络信息，用于inspect命令展示
+type NetworkInfo struct {
+	Name       string            // 网络名称，即网络模式
+	Bridge     string            // 网桥设备名，仅bridge网络有
+	Subnet     string            // 子网
+	Gateway    string            // 网关地址
+	Containers map[string]string // 已分配IP的容器，容器ID -> IP地址
+}
+
+// ErrNetworkNotFound 不存在指定的网络
+var ErrNetworkNotFound = errors.New("找不到网络")
+
 const (
 	// 网络模式
 	BridgeMode = "bridge"
 	HostMode   = "host"
 	NoneMode   = "none"
 
+	// ContainerModePrefix 加入其他容器的网络，如 "container:<ID>"
+	ContainerModePrefix = "container:"
+
 	// 默认的网桥设备名
 	DefaultBridge = "godocker0"
 
@@ -34,49 +53,113 @@ const (
 	DefaultIPPrefix = "172.17.0."
 )
 
-// SetupNetwork 为容器配置网络
-func SetupNetwork(netMode string, containerID string, pid int) (*NetworkConfig, error) {
-	// 创建网络配置
+// IP地址分配记录的存储目录
+var DefaultIPAMRoot = filepath.Join(config.DataRoot(), "network", "ipam")
+
+// ReserveNetwork 为容器预留网络资源
+// bridge模式下在创建容器时就分配IP地址，容器删除前一直保留
+func ReserveNetwork(netMode string, containerID string) (*NetworkConfig, error) {
+	// rootless模式下无法在主机上创建网桥和虚拟网卡
+	if netMode == BridgeMode && rootless.Enabled() {
+		fmt.Println("警告: rootless模式下不支持bridge网络，容器使用none网络")
+		netMode = NoneMode
+	}
+
 	netConfig := &NetworkConfig{
 		Mode: netMode,
 	}
 
-	// 根据网络模式进行配置
 	switch netMode {
 	case BridgeMode:
+		ipAddr, err := allocateIP(containerID)
+		if err != nil {
+			return nil, fmt.Errorf("分配IP地址失败: %v", err)
+		}
+		netConfig.IPAddress = ipAddr
+		netConfig.Gateway = DefaultGateway
+		netConfig.Subnet = DefaultSubnet
+
+	case HostMode, NoneMode:
+		// 不需要预留资源
+
+	default:
+		// 使用目标容器的网络，资源由目标容器预留
+		if !strings.HasPrefix(netMode, ContainerModePrefix) {
+			return nil, fmt.Errorf("不支持的网络模式: %s", netMode)
+		}
+	}
+
+	return netConfig, nil
+}
+
+// ReleaseNetwork 释放容器预留的网络资源
+func ReleaseNetwork(netConfig *NetworkConfig, containerID string) error {
+	if netConfig == nil || netConfig.IPAddress == "" {
+		return nil
+	}
+	return releaseIP(netConfig.IPAddress, containerID)
+}
+
+// InspectNetwork 获取网络的详细信息
+func InspectNetwork(name string) (*NetworkInfo, error) {
+	switch name {
+	case BridgeMode:
+		containers, err := allocatedIPs()
+		if err != nil {
+			return nil, fmt.Errorf("读取IP分配记录失败: %v", err)
+		}
+		return &NetworkInfo{
+			Name:       name,
+			Bridge:     DefaultBridge,
+			Subnet:     DefaultSubnet,
+			Gateway:    DefaultGateway,
+			Containers: containers,
+		}, nil
+
+	case HostMode, NoneMode:
+		return &NetworkInfo{Name: name, Containers: map[string]string{}}, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNetworkNotFound, name)
+	}
+}
+
+// SetupNetwork 为容器配置网络，netConfig 为创建容器时预留的网络配置
+func SetupNetwork(netConfig *NetworkConfig, containerID string, pid int) error {
+	// 根据网络模式进行配置
+	switch netConfig.Mode {
+	case BridgeMode:
+		if rootless.Enabled() {
+			return fmt.Errorf("rootless模式下无法配置bridge网络")
+		}
+
 		// 创建网桥（如果不存在）
 		if err := setupBridge(); err != nil {
-			return nil, fmt.Errorf("设置网桥失败: %v", err)
+			return fmt.Errorf("设置网桥失败: %v", err)
 		}
 
-		// 创建虚拟网卡对
+		// 创建虚拟网卡对，peer端移入容器后再重命名为eth0，避免与主机网卡重名
 		vethName := "veth-" + containerID[:8]
-		peerName := "eth0"
-
-		// 分配IP地址
-		ipAddr := allocateIP()
-		netConfig.IPAddress = ipAddr
-		netConfig.Gateway = DefaultGateway
-		netConfig.Subnet = DefaultSubnet
+		peerName := "ceth-" + containerID[:8]
 
 		// 创建虚拟网卡
 		if err := createVethPair(vethName, peerName); err != nil {
-			return nil, fmt.Errorf("创建虚拟网卡对失败: %v", err)
+			return fmt.Errorf("创建虚拟网卡对失败: %v", err)
 		}
 
 		// 将网卡移入容器命名空间
-		if err := setupContainerNetns(vethName, peerName, pid, ipAddr); err != nil {
-			return nil, fmt.Errorf("设置容器网络命名空间失败: %v", err)
+		if err := setupContainerNetns(vethName, peerName, pid, netConfig.IPAddress); err != nil {
+			return fmt.Errorf("设置容器网络命名空间失败: %v", err)
 		}
 
 		// 连接网卡到网桥
 		if err := connectVethToBridge(vethName, DefaultBridge); err != nil {
-			return nil, fmt.Errorf("连接网卡到网桥失败: %v", err)
+			return fmt.Errorf("连接网卡到网桥失败: %v", err)
 		}
 
 		// 设置网络转发和NAT
 		if err := setupNAT(DefaultBridge, DefaultSubnet); err != nil {
-			return nil, fmt.Errorf("设置NAT失败: %v", err)
+			return fmt.Errorf("设置NAT失败: %v", err)
 		}
 
 	case HostMode:
@@ -88,10 +171,13 @@ func SetupNetwork(netMode string, containerID string, pid int) (*NetworkConfig,
 		fmt.Println("容器未配置网络")
 
 	default:
-		return nil, fmt.Errorf("不支持的网络模式: %s", netMode)
+		// 容器进程启动时已经加入了目标容器的network namespace
+		if !strings.HasPrefix(netConfig.Mode, ContainerModePrefix) {
+			return fmt.Errorf("不支持的网络模式: %s", netConfig.Mode)
+		}
 	}
 
-	return netConfig, nil
+	return nil
 }
 
 // 设置网桥
@@ -209,16 +295,72 @@ func deviceExists(name string) (bool, error) {
 }
 
 // 分配IP地址
-func allocateIP() string {
-	// 简化实现，实际应该有更复杂的IP地址分配机制
-	// 这里简单返回一个固定IP段的随机IP
-	// 在实际实现中，应该维护已分配IP的列表
+// 每个已分配的IP在 DefaultIPAMRoot 下对应一个文件，文件内容为占用该IP的容器ID，
+// 使用 O_EXCL 创建文件保证多个godocker进程并发分配时不会冲突
+func allocateIP(containerID string) (string, error) {
+	if err := os.MkdirAll(DefaultIPAMRoot, 0755); err != nil {
+		return "", err
+	}
+
+	// 0是网络地址，1是网关，255是广播地址
+	for lastOctet := 2; lastOctet < 255; lastOctet++ {
+		ipAddr := DefaultIPPrefix + strconv.Itoa(lastOctet)
+		file, err := os.OpenFile(filepath.Join(DefaultIPAMRoot, ipAddr), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			if os.IsExist(err) {
+				continue
+			}
+			return "", err
+		}
+
+		_, err = file.WriteString(containerID)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+		return ipAddr, nil
+	}
 
-	// 简单起见，使用进程ID的后两位作为IP地址的最后部分
-	lastOctet := os.Getpid() % 254
-	if lastOctet < 2 {
-		lastOctet = 100 // 避免使用0和1
+	return "", fmt.Errorf("子网 %s 中已没有可用的IP地址", DefaultSubnet)
+}
+
+// 释放IP地址，只释放被指定容器占用的IP
+func releaseIP(ipAddr, containerID string) error {
+	path := filepath.Join(DefaultIPAMRoot, ipAddr)
+	owner, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if string(owner) != containerID {
+		return nil
+	}
+
+	return os.Remove(path)
+}
+
+// 读取所有已分配的IP地址，返回容器ID到IP地址的映射
+func allocatedIPs() (map[string]string, error) {
+	result := make(map[string]string)
+
+	entries, err := os.ReadDir(DefaultIPAMRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		owner, err := os.ReadFile(filepath.Join(DefaultIPAMRoot, entry.Name()))
+		if err != nil {
+			continue
+		}
+		result[string(owner)] = entry.Name()
 	}
 
-	return DefaultIPPrefix + strconv.Itoa(lastOctet)
+	return result, nil
 }
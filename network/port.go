@@ -0,0 +1,90 @@
+package network
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// PortMapping 发布到主机上的端口
+type PortMapping struct {
+	HostPort      int    // 主机端口
+	ContainerPort int    // 容器端口
+	Protocol      string // 协议，tcp 或 udp
+}
+
+func (p PortMapping) String() string {
+	return fmt.Sprintf("%d:%d/%s", p.HostPort, p.ContainerPort, p.Protocol)
+}
+
+// ParsePortMapping 解析 -p 参数，格式为 主机端口:容器端口[/协议]，协议默认为tcp
+func ParsePortMapping(spec string) (PortMapping, error) {
+	mapping := PortMapping{Protocol: "tcp"}
+
+	ports := spec
+	if i := strings.Index(spec, "/"); i >= 0 {
+		ports, mapping.Protocol = spec[:i], spec[i+1:]
+	}
+	if mapping.Protocol != "tcp" && mapping.Protocol != "udp" {
+		return mapping, fmt.Errorf("无效的端口映射 %s: 不支持的协议 %s", spec, mapping.Protocol)
+	}
+
+	parts := strings.Split(ports, ":")
+	if len(parts) != 2 {
+		return mapping, fmt.Errorf("无效的端口映射 %s，格式为 主机端口:容器端口[/协议]", spec)
+	}
+	for i, dst := range []*int{&mapping.HostPort, &mapping.ContainerPort} {
+		port, err := strconv.Atoi(parts[i])
+		if err != nil || port < 1 || port > 65535 {
+			return mapping, fmt.Errorf("无效的端口映射 %s: 端口 %s 超出范围", spec, parts[i])
+		}
+		*dst = port
+	}
+	return mapping, nil
+}
+
+// PublishPorts 通过DNAT把主机端口转发到bridge网络中的IP
+func PublishPorts(netConfig *NetworkConfig, ports []PortMapping) error {
+	if len(ports) == 0 {
+		return nil
+	}
+	if netConfig == nil || netConfig.Mode != BridgeMode || netConfig.IPAddress == "" {
+		return fmt.Errorf("只有bridge网络可以发布端口")
+	}
+
+	for _, port := range ports {
+		for _, rule := range portRules(netConfig.IPAddress, port) {
+			args := append([]string{"-t", "nat", "-A"}, rule...)
+			if out, err := exec.Command("iptables", args...).CombinedOutput(); err != nil {
+				UnpublishPorts(netConfig, ports)
+				return fmt.Errorf("发布端口 %s 失败: %v %s", port, err, strings.TrimSpace(string(out)))
+			}
+		}
+	}
+	return nil
+}
+
+// UnpublishPorts 删除发布端口时添加的DNAT规则，规则不存在时忽略
+func UnpublishPorts(netConfig *NetworkConfig, ports []PortMapping) {
+	if netConfig == nil || netConfig.IPAddress == "" {
+		return
+	}
+	for _, port := range ports {
+		for _, rule := range portRules(netConfig.IPAddress, port) {
+			args := append([]string{"-t", "nat", "-D"}, rule...)
+			exec.Command("iptables", args...).Run()
+		}
+	}
+}
+
+// portRules 返回一个端口映射需要的DNAT规则（不含 -t nat -A/-D）
+// PREROUTING 处理从其他主机进来的连接，OUTPUT 处理主机自己访问本机地址的连接
+func portRules(ip string, port PortMapping) [][]string {
+	match := []string{"-p", port.Protocol, "--dport", strconv.Itoa(port.HostPort),
+		"-j", "DNAT", "--to-destination", ip + ":" + strconv.Itoa(port.ContainerPort)}
+	return [][]string{
+		append([]string{"PREROUTING", "-m", "addrtype", "--dst-type", "LOCAL"}, match...),
+		append([]string{"OUTPUT", "!", "-d", "127.0.0.0/8", "-m", "addrtype", "--dst-type", "LOCAL"}, match...),
+	}
+}
@@ -0,0 +1,67 @@
+package resources
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// AnyDevice 设备规则中表示任意设备号
+const AnyDevice = -1
+
+// DeviceRule 设备cgroup规则，允许访问某类设备
+type DeviceRule struct {
+	Type        string // c为字符设备，b为块设备
+	Major       int64  // 主设备号，AnyDevice表示任意
+	Minor       int64  // 次设备号，AnyDevice表示任意
+	Permissions string // r读、w写、m创建设备文件
+}
+
+// String 转换为 devices.allow 使用的格式，如 "c 1:3 rwm"
+func (r DeviceRule) String() string {
+	return fmt.Sprintf("%s %s:%s %s", r.Type, deviceNumber(r.Major), deviceNumber(r.Minor), r.Permissions)
+}
+
+func deviceNumber(n int64) string {
+	if n == AnyDevice {
+		return "*"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// 设置设备访问限制：先禁止访问所有设备，再逐条允许
+func setupDevices(cgroupName string, pid int, rules []DeviceRule) error {
+	// cgroup v2没有devices子系统，在统一层级下创建目录只会留下一个无用的cgroup
+	if _, err := os.Stat(filepath.Join(cgroupDevicePath, "devices.allow")); err != nil {
+		return fmt.Errorf("主机没有挂载cgroup v1的devices子系统 (%s)，无法限制容器访问设备", cgroupDevicePath)
+	}
+
+	// 创建devices cgroup子系统
+	devicePath := filepath.Join(cgroupDevicePath, cgroupName)
+	if err := os.MkdirAll(devicePath, 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(devicePath, "devices.deny"), []byte("a"), 0644); err != nil {
+		return err
+	}
+
+	// 每次写入只能包含一条规则
+	for _, rule := range rules {
+		if err := ioutil.WriteFile(filepath.Join(devicePath, "devices.allow"), []byte(rule.String()), 0644); err != nil {
+			return fmt.Errorf("添加规则 %s 失败: %v", rule, err)
+		}
+	}
+
+	// 将进程加入到cgroup
+	if err := ioutil.WriteFile(
+		filepath.Join(devicePath, "tasks"),
+		[]byte(strconv.Itoa(pid)),
+		0644); err != nil {
+		return err
+	}
+
+	return nil
+}
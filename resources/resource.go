@@ -3,10 +3,13 @@ package resources
 import (
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/akm/godocker/rootless"
 )
 
 // ResourceConfig 定义资源限制配置
@@ -14,6 +17,9 @@ type ResourceConfig struct {
 	MemoryLimit string // 内存限制，例如 "100m"
 	CpuSet      string // CPU核心设置，例如 "0,1"
 	CpuShare    int    // CPU共享权重
+
+	// 允许访问的设备，非空时容器只能访问这些设备
+	Devices []DeviceRule `json:",omitempty"`
 }
 
 const (
@@ -21,18 +27,31 @@ const (
 	cgroupMemoryPath = "/sys/fs/cgroup/memory"
 	cgroupCpuPath    = "/sys/fs/cgroup/cpu"
 	cgroupCpusetPath = "/sys/fs/cgroup/cpuset"
+	cgroupDevicePath = "/sys/fs/cgroup/devices"
 )
 
 // ApplyResourceLimits 应用资源限制到指定进程
 func ApplyResourceLimits(pid int, config ResourceConfig) error {
 	// 如果没有设置任何资源限制，直接返回
-	if config.MemoryLimit == "" && config.CpuSet == "" && config.CpuShare == 0 {
+	if config.MemoryLimit == "" && config.CpuSet == "" && config.CpuShare == 0 && len(config.Devices) == 0 {
 		return nil
 	}
 
+	// rootless模式下没有cgroup目录的写权限
+	if rootless.Enabled() {
+		return fmt.Errorf("rootless模式下无法使用cgroup，资源限制不会生效")
+	}
+
 	// 创建cgroup子系统
 	cgroupName := "godocker-" + strconv.Itoa(pid)
 
+	// 最先应用设备访问限制，其他限制失败时容器也不会在没有设备限制的情况下运行
+	if len(config.Devices) > 0 {
+		if err := setupDevices(cgroupName, pid, config.Devices); err != nil {
+			return fmt.Errorf("设置设备访问限制失败: %v", err)
+		}
+	}
+
 	// 应用内存限制
 	if config.MemoryLimit != "" {
 		if err := setupMemoryLimit(cgroupName, pid, config.MemoryLimit); err != nil {
@@ -57,10 +76,53 @@ func ApplyResourceLimits(pid int, config ResourceConfig) error {
 	return nil
 }
 
+// RemoveResourceLimits 删除容器进程退出后留下的cgroup
+func RemoveResourceLimits(pid int) {
+	cgroupName := "godocker-" + strconv.Itoa(pid)
+	for _, root := range []string{cgroupMemoryPath, cgroupCpuPath, cgroupCpusetPath, cgroupDevicePath} {
+		// cgroup目录只能用rmdir删除，不存在时忽略错误
+		os.Remove(filepath.Join(root, cgroupName))
+	}
+}
+
+// CgroupProcsPaths 返回容器进程所在cgroup的 cgroup.procs 文件
+// 把进程ID写入这些文件后，该进程与容器进程受同样的资源限制，exec命令执行的进程需要加入
+func CgroupProcsPaths(pid int) []string {
+	cgroupName := "godocker-" + strconv.Itoa(pid)
+
+	var paths []string
+	for _, root := range []string{cgroupMemoryPath, cgroupCpuPath, cgroupCpusetPath, cgroupDevicePath} {
+		path := filepath.Join(root, cgroupName, "cgroup.procs")
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// OOMKilled 判断进程所在的内存cgroup是否发生过OOM Kill
+func OOMKilled(pid int) bool {
+	data, err := ioutil.ReadFile(filepath.Join(cgroupMemoryPath, "godocker-"+strconv.Itoa(pid), "memory.oom_control"))
+	if err != nil {
+		return false
+	}
+
+	// memory.oom_control 中 oom_kill 字段记录了被OOM杀死的进程数
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			count, err := strconv.Atoi(fields[1])
+			return err == nil && count > 0
+		}
+	}
+
+	return false
+}
+
 // 设置内存限制
 func setupMemoryLimit(cgroupName string, pid int, memoryLimit string) error {
 	// 转换内存限制为字节
-	memoryBytes, err := parseMemoryLimit(memoryLimit)
+	memoryBytes, err := ParseSize(memoryLimit)
 	if err != nil {
 		return err
 	}
@@ -161,25 +223,29 @@ func setupCpuShare(cgroupName string, pid int, cpuShare int) error {
 	return nil
 }
 
-// parseMemoryLimit 将内存限制字符串转换为字节数
-func parseMemoryLimit(memoryLimit string) (int64, error) {
-	memoryLimit = strings.ToLower(memoryLimit)
+// ParseSize 将 512k、64m、1g 这样的大小字符串转换为字节数
+// 内存限制、数据卷和tmpfs的大小都使用这种格式
+func ParseSize(size string) (int64, error) {
+	number := strings.ToLower(size)
 	var multiplier int64 = 1
 
-	if strings.HasSuffix(memoryLimit, "k") {
+	if strings.HasSuffix(number, "k") {
 		multiplier = 1024
-		memoryLimit = strings.TrimSuffix(memoryLimit, "k")
-	} else if strings.HasSuffix(memoryLimit, "m") {
+		number = strings.TrimSuffix(number, "k")
+	} else if strings.HasSuffix(number, "m") {
 		multiplier = 1024 * 1024
-		memoryLimit = strings.TrimSuffix(memoryLimit, "m")
-	} else if strings.HasSuffix(memoryLimit, "g") {
+		number = strings.TrimSuffix(number, "m")
+	} else if strings.HasSuffix(number, "g") {
 		multiplier = 1024 * 1024 * 1024
-		memoryLimit = strings.TrimSuffix(memoryLimit, "g")
+		number = strings.TrimSuffix(number, "g")
 	}
 
-	value, err := strconv.ParseInt(memoryLimit, 10, 64)
-	if err != nil {
-		return 0, fmt.Errorf("无效的内存限制格式: %s", memoryLimit)
+	value, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("无效的大小格式: %s", size)
+	}
+	if value > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("大小超出范围: %s", size)
 	}
 
 	return value * multiplier, nil
@@ -0,0 +1,52 @@
+package resources
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{"1", 1, false},
+		{"4096", 4096, false},
+		{"512k", 512 * 1024, false},
+		{"512K", 512 * 1024, false},
+		{"64m", 64 * 1024 * 1024, false},
+		{"64M", 64 * 1024 * 1024, false},
+		{"1g", 1024 * 1024 * 1024, false},
+		{"2G", 2 * 1024 * 1024 * 1024, false},
+		{"9223372036854775807", 9223372036854775807, false},
+		{"8589934591g", 8589934591 * 1024 * 1024 * 1024, false},
+
+		{"", 0, true},
+		{"0", 0, true},
+		{"0m", 0, true},
+		{"-1", 0, true},
+		{"-64m", 0, true},
+		{"m", 0, true},
+		{"k", 0, true},
+		{"1.5g", 0, true},
+		{"10t", 0, true},
+		{"10b", 0, true},
+		{"10mb", 0, true},
+		{"1gm", 0, true},
+		{" 64m", 0, true},
+		{"64 m", 0, true},
+		{"abc", 0, true},
+		{"8589934592g", 0, true},
+		{"9223372036854775808", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			got, err := ParseSize(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSize(%q) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.size, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+//go:build linux
+// +build linux
+
+// Package rootless 支持普通用户在没有root权限的情况下运行godocker
+//
+// 非root用户运行godocker时，所有需要挂载的命令都在同一对user namespace和mount namespace中执行。
+// 这对namespace由一个长期运行的pause进程持有，第一次使用时创建，
+// 当前用户映射为其中的root，/etc/subuid 和 /etc/subgid 中分配给当前用户的ID映射为其他用户。
+// 之后的命令通过nsenter加入pause进程的namespace，create时做的挂载在start时仍然存在。
+// 这样可以挂载overlayfs、proc等文件系统并创建容器的其他namespace，
+// 但仍然无法修改主机的cgroup和网络，这些功能会降级或跳过。
+package rootless
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/akm/godocker/container/nsenter"
+)
+
+// EnvRootless 在重新执行的子进程中标记rootless模式的环境变量
+// 监控进程继承该变量，因此在user namespace中也能判断出是rootless模式
+const EnvRootless = "_GODOCKER_ROOTLESS"
+
+// envReexec 标记由Reexec重新执行、启动时由nsenter加入了pause进程namespace的进程
+const envReexec = "_GODOCKER_ROOTLESS_REEXEC"
+
+// PauseCommand 持有rootless模式namespace的pause进程的子命令
+const PauseCommand = "rootless-pause"
+
+func init() {
+	// nsenter的环境变量只对重新执行的这个进程有效，不能传给它启动的监控进程和容器进程，
+	// 否则容器进程启动时会重新加入pause进程的mount namespace
+	if os.Getenv(envReexec) != "" {
+		os.Unsetenv(envReexec)
+		os.Unsetenv(nsenter.EnvPid)
+		os.Unsetenv(nsenter.EnvNamespaces)
+	}
+}
+
+// Enabled 是否以rootless模式运行
+func Enabled() bool {
+	return os.Geteuid() != 0 || os.Getenv(EnvRootless) != ""
+}
+
+// Reexec 在pause进程的user namespace和mount namespace中重新执行当前命令，返回子进程的退出码
+// pause进程不存在时先创建
+func Reexec() int {
+	if !nsenter.Supported() {
+		fmt.Fprintln(os.Stderr, "当前构建不支持rootless模式，需要在Linux上启用cgo编译")
+		return 1
+	}
+
+	pid, err := ensurePause()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "创建user namespace失败: %v\n", err)
+		fmt.Fprintln(os.Stderr, "rootless模式需要内核允许普通用户创建user namespace")
+		return 1
+	}
+
+	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		EnvRootless+"=1",
+		envReexec+"=1",
+		nsenter.EnvPid+"="+strconv.Itoa(pid),
+		nsenter.EnvNamespaces+"=user,mnt",
+	)
+
+	// 终端产生的信号会同时发给子进程，只转发单独发给当前进程的信号
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "启动命令失败: %v\n", err)
+		return 1
+	}
+
+	go func() {
+		for sig := range signals {
+			switch sig {
+			case syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2:
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	cmd.Wait()
+	status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return cmd.ProcessState.ExitCode()
+}
+
+// Pause pause进程的主函数，只持有namespace，直到收到SIGTERM
+func Pause() {
+	signal.Ignore(syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	<-signals
+}
+
+// ensurePause 返回pause进程的PID，不存在时创建一个
+// 使用文件锁，避免多个godocker进程同时创建
+func ensurePause() (int, error) {
+	dir, err := runtimeDir()
+	if err != nil {
+		return 0, err
+	}
+
+	lock, err := os.OpenFile(filepath.Join(dir, "pause.lock"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("打开锁文件失败: %v", err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return 0, fmt.Errorf("锁定 %s 失败: %v", lock.Name(), err)
+	}
+
+	pidFile := filepath.Join(dir, "pause.pid")
+	if data, err := os.ReadFile(pidFile); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && isPause(pid) {
+			return pid, nil
+		}
+	}
+
+	pid, err := startPause()
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(pid)), 0600); err != nil {
+		syscall.Kill(pid, syscall.SIGKILL)
+		return 0, fmt.Errorf("保存pause进程PID失败: %v", err)
+	}
+	return pid, nil
+}
+
+// startPause 在新的user namespace和mount namespace中启动pause进程，并写入ID映射
+// pause进程脱离当前会话，在当前命令退出后继续运行
+func startPause() (int, error) {
+	cmd := exec.Command("/proc/self/exe", PauseCommand)
+	cmd.Dir = "/"
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		Setsid:     true,
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	pid := cmd.Process.Pid
+	if err := writeIDMappings(pid); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, err
+	}
+	cmd.Process.Release()
+	return pid, nil
+}
+
+// isPause 检查PID是否仍然是pause进程，避免PID被其他进程复用
+func isPause(pid int) bool {
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return false
+	}
+	args := strings.Split(string(bytes.TrimRight(cmdline, "\x00")), "\x00")
+	return len(args) == 2 && args[1] == PauseCommand
+}
+
+// runtimeDir 返回保存pause进程PID的目录
+// 优先使用 $XDG_RUNTIME_DIR/godocker，主机重启后会被清空
+func runtimeDir() (string, error) {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	dir := filepath.Join(base, "godocker")
+	if base == "" {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("godocker-%d", os.Getuid()))
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("创建目录 %s 失败: %v", dir, err)
+	}
+
+	// 公共的临时目录可能被其他用户抢先创建
+	var stat syscall.Stat_t
+	if err := syscall.Lstat(dir, &stat); err != nil {
+		return "", err
+	}
+	if int(stat.Uid) != os.Getuid() || stat.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		return "", fmt.Errorf("目录 %s 不属于当前用户", dir)
+	}
+	return dir, nil
+}
+
+// writeIDMappings 写入pause进程的ID映射
+// 当前用户映射为root，从属ID从1开始依次映射；普通用户只能通过setuid的newuidmap/newgidmap写入多个映射。
+// 没有分配从属ID或没有安装这两个工具时只映射当前用户，容器内无法切换到其他用户
+func writeIDMappings(pid int) error {
+	name := strconv.Itoa(os.Getuid())
+	uidStart, uidCount, uidErr := ReadSubordinateRange(SubuidPath, name)
+	gidStart, gidCount, gidErr := ReadSubordinateRange(SubgidPath, name)
+	_, uidmapErr := exec.LookPath("newuidmap")
+	_, gidmapErr := exec.LookPath("newgidmap")
+
+	if uidErr != nil || gidErr != nil || uidmapErr != nil || gidmapErr != nil {
+		fmt.Fprintln(os.Stderr, "警告: 没有找到当前用户的从属ID或newuidmap/newgidmap，只映射当前用户，容器内无法切换到其他用户")
+		return writeSingleMapping(pid)
+	}
+
+	if err := runIDMapTool("newuidmap", pid, os.Getuid(), uidStart, uidCount); err != nil {
+		return err
+	}
+	return runIDMapTool("newgidmap", pid, os.Getgid(), gidStart, gidCount)
+}
+
+func runIDMapTool(tool string, pid, id, start, count int) error {
+	args := []string{strconv.Itoa(pid), "0", strconv.Itoa(id), "1", "1", strconv.Itoa(start), strconv.Itoa(count)}
+	if output, err := exec.Command(tool, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s 失败: %v: %s", tool, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// writeSingleMapping 只把当前用户映射为root
+// 普通用户写入gid_map之前必须禁用setgroups
+func writeSingleMapping(pid int) error {
+	files := []struct {
+		name, content string
+	}{
+		{"uid_map", fmt.Sprintf("0 %d 1", os.Getuid())},
+		{"setgroups", "deny"},
+		{"gid_map", fmt.Sprintf("0 %d 1", os.Getgid())},
+	}
+	for _, f := range files {
+		path := fmt.Sprintf("/proc/%d/%s", pid, f.name)
+		if err := os.WriteFile(path, []byte(f.content), 0); err != nil {
+			return fmt.Errorf("写入 %s 失败: %v", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+package rootless
+
+import (
+	"fmt"
+	"os"
+)
+
+// PauseCommand 持有rootless模式namespace的pause进程的子命令
+const PauseCommand = "rootless-pause"
+
+// Enabled 是否以rootless模式运行（非Linux平台不支持rootless模式）
+func Enabled() bool {
+	return false
+}
+
+// Reexec 在pause进程的namespace中重新执行当前命令（非Linux平台不支持）
+func Reexec() int {
+	fmt.Fprintln(os.Stderr, "当前平台不支持rootless模式")
+	return 1
+}
+
+// Pause pause进程的主函数（非Linux平台不支持）
+func Pause() {
+	fmt.Fprintln(os.Stderr, "当前平台不支持rootless模式")
+	os.Exit(1)
+}
@@ -0,0 +1,61 @@
+package rootless
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// 从属UID和GID的分配文件，每行格式为 用户名或UID:起始ID:数量
+const (
+	SubuidPath = "/etc/subuid"
+	SubgidPath = "/etc/subgid"
+)
+
+// ReadSubordinateRange 读取分配给用户的从属ID，返回起始ID和数量
+// name可以是用户名或UID，文件中的记录使用其中任意一种都能匹配；有多段时只使用第一段
+func ReadSubordinateRange(path, name string) (int, int, error) {
+	names := []string{name}
+	if u, err := user.Lookup(name); err == nil {
+		names = append(names, u.Uid)
+	} else if u, err := user.LookupId(name); err == nil {
+		names = append(names, u.Username)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("读取 %s 失败: %v", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(fields) != 3 || !matchesName(names, fields[0]) {
+			continue
+		}
+
+		start, err1 := strconv.Atoi(fields[1])
+		count, err2 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil || start < 0 || count <= 0 {
+			return 0, 0, fmt.Errorf("%s 中的无效记录: %s", path, scanner.Text())
+		}
+		return start, count, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, fmt.Errorf("读取 %s 失败: %v", path, err)
+	}
+	return 0, 0, fmt.Errorf("%s 中没有用户 %s 的从属ID", path, name)
+}
+
+func matchesName(names []string, s string) bool {
+	for _, name := range names {
+		if name == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/akm/godocker/config"
+	"golang.org/x/sys/unix"
+)
+
+// Driver 存储驱动，管理镜像层和容器可写层
+// 每一层由ID标识，创建时指定父层，挂载后得到包含父层内容的完整文件系统
+type Driver interface {
+	// Name 返回驱动名称
+	Name() string
+
+	// Create 创建一个新层，parent 为空表示最底层
+	Create(id, parent string) error
+
+	// Mount 挂载层，返回可以访问该层完整内容的目录，已挂载时直接返回
+	Mount(id string) (string, error)
+
+	// Unmount 卸载层，未挂载时不做任何操作
+	Unmount(id string) error
+
+	// Diff 返回保存该层自身修改的目录，拉取镜像时把层的内容解压到这里
+	Diff(id string) (string, error)
+
+	// Remove 卸载并删除层
+	Remove(id string) error
+}
+
+// IDMapper 可以把下层以idmapped mount方式挂载的驱动
+// 容器使用user namespace时，镜像中文件的属主按容器的ID映射显示，不需要逐个修改属主
+type IDMapper interface {
+	// MountIDMapped 与 Mount 相同，但所有下层按 usernsFd 指向的user namespace的ID映射挂载
+	MountIDMapped(id string, usernsFd int) (string, error)
+}
+
+// ErrIDMapUnsupported 内核或文件系统不支持idmapped mount
+var ErrIDMapUnsupported = errors.New("不支持idmapped mount")
+
+// 存储驱动的数据目录，每个驱动使用以驱动名命名的子目录
+var DefaultStorageRoot = filepath.Join(config.DataRoot(), "storage")
+
+const (
+	// 驱动名称
+	OverlayDriver = "overlay"
+	VfsDriver     = "vfs"
+)
+
+// 自动选择驱动时的优先顺序
+var driverPriority = []string{OverlayDriver, VfsDriver}
+
+// GetDriver 按名称获取存储驱动
+func GetDriver(name string) (Driver, error) {
+	root := filepath.Join(DefaultStorageRoot, name)
+
+	switch name {
+	case OverlayDriver:
+		return &overlayDriver{root: root}, nil
+	case VfsDriver:
+		return &vfsDriver{root: root}, nil
+	default:
+		return nil, fmt.Errorf("不支持的存储驱动: %s", name)
+	}
+}
+
+// DefaultDriver 获取新建镜像和容器使用的存储驱动
+// 优先使用全局配置中指定的驱动；未指定时沿用已经存有数据的驱动，
+// 否则检测当前系统是否支持overlayfs，不支持时使用vfs
+func DefaultDriver() (Driver, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.StorageDriver != "" {
+		return GetDriver(cfg.StorageDriver)
+	}
+
+	for _, name := range driverPriority {
+		entries, err := os.ReadDir(filepath.Join(DefaultStorageRoot, name))
+		if err == nil && len(entries) > 0 {
+			return GetDriver(name)
+		}
+	}
+
+	if err := probeOverlay(filepath.Join(DefaultStorageRoot, OverlayDriver)); err == nil {
+		return GetDriver(OverlayDriver)
+	}
+	return GetDriver(VfsDriver)
+}
+
+// IsMountPoint 判断路径是否为挂载点，路径不存在时返回false
+// 挂载点与其父目录位于不同的设备上
+func IsMountPoint(path string) (bool, error) {
+	var st, parent unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := unix.Lstat(filepath.Dir(path), &parent); err != nil {
+		return false, err
+	}
+	return st.Dev != parent.Dev, nil
+}
+
+// validLayerId 检查层ID，避免通过ID访问驱动目录之外的路径
+func validLayerId(id string) error {
+	if id == "" || id == "." || id == ".." || filepath.Base(id) != id {
+		return fmt.Errorf("无效的层ID: %q", id)
+	}
+	return nil
+}
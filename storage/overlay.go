@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// overlayDriver 基于overlayfs的存储驱动
+// 每层的目录结构：
+//
+//	<root>/<id>/diff    该层自身的修改，作为上层的lowerdir或自身的upperdir
+//	<root>/<id>/work    overlayfs的工作目录
+//	<root>/<id>/merged  挂载点
+//	<root>/<id>/lower   所有祖先层的ID，从近到远用冒号分隔
+//	<root>/<id>/idmap   挂载时临时存放idmapped下层的目录
+type overlayDriver struct {
+	root string
+}
+
+func (d *overlayDriver) Name() string {
+	return OverlayDriver
+}
+
+func (d *overlayDriver) Create(id, parent string) error {
+	if err := validLayerId(id); err != nil {
+		return err
+	}
+
+	// 祖先层列表 = 父层 + 父层的祖先层
+	var lower []string
+	if parent != "" {
+		parentLower, err := d.lowerIds(parent)
+		if err != nil {
+			return err
+		}
+		lower = append([]string{parent}, parentLower...)
+	}
+
+	dir := filepath.Join(d.root, id)
+	for _, name := range []string{"diff", "work", "merged"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			os.RemoveAll(dir)
+			return fmt.Errorf("创建层目录失败: %v", err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "lower"), []byte(strings.Join(lower, ":")), 0644); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("保存层信息失败: %v", err)
+	}
+	return nil
+}
+
+func (d *overlayDriver) Mount(id string) (string, error) {
+	return d.mount(id, -1)
+}
+
+func (d *overlayDriver) MountIDMapped(id string, usernsFd int) (string, error) {
+	return d.mount(id, usernsFd)
+}
+
+// mount 挂载层，usernsFd 不小于0时下层先以idmapped方式绑定挂载，再作为lowerdir
+func (d *overlayDriver) mount(id string, usernsFd int) (string, error) {
+	if err := validLayerId(id); err != nil {
+		return "", err
+	}
+
+	lower, err := d.lowerIds(id)
+	if err != nil {
+		return "", err
+	}
+
+	// 最底层没有需要合并的内容，直接使用它的diff目录
+	dir := filepath.Join(d.root, id)
+	if len(lower) == 0 {
+		return filepath.Join(dir, "diff"), nil
+	}
+
+	merged := filepath.Join(dir, "merged")
+	mounted, err := IsMountPoint(merged)
+	if err != nil {
+		return "", err
+	}
+	if mounted {
+		return merged, nil
+	}
+
+	lowerDirs := make([]string, len(lower))
+	for i, layer := range lower {
+		lowerDirs[i] = filepath.Join(d.root, layer, "diff")
+	}
+	if usernsFd >= 0 {
+		// overlayfs挂载后自己持有下层的引用，临时的idmapped挂载点随即卸载
+		idmapDir := filepath.Join(dir, "idmap")
+		defer unmountIDMapped(idmapDir)
+		for i := range lowerDirs {
+			target := filepath.Join(idmapDir, strconv.Itoa(i))
+			if err := idmapMount(lowerDirs[i], target, usernsFd); err != nil {
+				return "", fmt.Errorf("%w: %v", ErrIDMapUnsupported, err)
+			}
+			lowerDirs[i] = target
+		}
+	}
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		strings.Join(lowerDirs, ":"), filepath.Join(dir, "diff"), filepath.Join(dir, "work"))
+
+	if err := unix.Mount("overlay", merged, "overlay", 0, data); err != nil {
+		// 5.19之前的内核不接受idmapped的下层
+		if usernsFd >= 0 && err == unix.EINVAL {
+			return "", fmt.Errorf("%w: 挂载overlayfs失败: %v", ErrIDMapUnsupported, err)
+		}
+		return "", fmt.Errorf("挂载overlayfs失败: %v", err)
+	}
+	return merged, nil
+}
+
+// idmapMount 把source以idmapped方式绑定挂载到target，文件属主按user namespace的ID映射转换
+// 需要内核5.12以上，且source所在的文件系统支持idmapped mount
+func idmapMount(source, target string, usernsFd int) error {
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+
+	fd, err := unix.OpenTree(unix.AT_FDCWD, source, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("open_tree %s: %v", source, err)
+	}
+	defer unix.Close(fd)
+
+	attr := &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_IDMAP, Userns_fd: uint64(usernsFd)}
+	if err := unix.MountSetattr(fd, "", unix.AT_EMPTY_PATH, attr); err != nil {
+		return fmt.Errorf("mount_setattr %s: %v", source, err)
+	}
+	if err := unix.MoveMount(fd, "", unix.AT_FDCWD, target, unix.MOVE_MOUNT_F_EMPTY_PATH); err != nil {
+		return fmt.Errorf("move_mount %s: %v", target, err)
+	}
+	return nil
+}
+
+// unmountIDMapped 卸载并删除临时的idmapped挂载点
+// 只用rmdir删除，卸载失败时不会删除下层中的文件
+func unmountIDMapped(dir string) {
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		target := filepath.Join(dir, entry.Name())
+		unix.Unmount(target, unix.MNT_DETACH)
+		os.Remove(target)
+	}
+	os.Remove(dir)
+}
+
+func (d *overlayDriver) Unmount(id string) error {
+	if err := validLayerId(id); err != nil {
+		return err
+	}
+
+	merged := filepath.Join(d.root, id, "merged")
+	mounted, err := IsMountPoint(merged)
+	if err != nil || !mounted {
+		return err
+	}
+
+	// 使用延迟卸载，容器挂载在根目录下的proc等文件系统会一起被卸载
+	if err := unix.Unmount(merged, unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("卸载overlayfs失败: %v", err)
+	}
+	return nil
+}
+
+func (d *overlayDriver) Diff(id string) (string, error) {
+	if err := validLayerId(id); err != nil {
+		return "", err
+	}
+	return filepath.Join(d.root, id, "diff"), nil
+}
+
+func (d *overlayDriver) Remove(id string) error {
+	if err := d.Unmount(id); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(d.root, id))
+}
+
+// lowerIds 读取层的祖先层ID
+func (d *overlayDriver) lowerIds(id string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(d.root, id, "lower"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("层 %s 不存在", id)
+		}
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(data), ":"), nil
+}
+
+// probeOverlay 在驱动目录下尝试挂载一次overlayfs，检测当前系统是否可用
+// 内核不支持overlayfs或者数据目录本身位于不支持作为upperdir的文件系统上时会失败
+func probeOverlay(root string) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp(root, ".probe-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"lower", "upper", "work", "merged"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			return err
+		}
+	}
+
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		filepath.Join(dir, "lower"), filepath.Join(dir, "upper"), filepath.Join(dir, "work"))
+	merged := filepath.Join(dir, "merged")
+	if err := unix.Mount("overlay", merged, "overlay", 0, data); err != nil {
+		return err
+	}
+	return unix.Unmount(merged, 0)
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// vfsDriver 不依赖任何内核特性的存储驱动
+// 每层是 <root>/dir/<id> 下的一个完整目录，创建时复制父层的全部内容，
+// 占用空间大、创建慢，但可以在任何文件系统上使用
+type vfsDriver struct {
+	root string
+}
+
+func (d *vfsDriver) Name() string {
+	return VfsDriver
+}
+
+func (d *vfsDriver) Create(id, parent string) error {
+	if err := validLayerId(id); err != nil {
+		return err
+	}
+
+	dir := d.dir(id)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return fmt.Errorf("创建层目录失败: %v", err)
+	}
+
+	if parent == "" {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return fmt.Errorf("创建层目录失败: %v", err)
+		}
+		return nil
+	}
+
+	if err := validLayerId(parent); err != nil {
+		return err
+	}
+	if _, err := os.Stat(d.dir(parent)); err != nil {
+		return fmt.Errorf("层 %s 不存在", parent)
+	}
+	if err := CopyDir(d.dir(parent), dir); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("复制父层 %s 失败: %v", parent, err)
+	}
+	return nil
+}
+
+func (d *vfsDriver) Mount(id string) (string, error) {
+	if err := validLayerId(id); err != nil {
+		return "", err
+	}
+	dir := d.dir(id)
+	if _, err := os.Stat(dir); err != nil {
+		return "", fmt.Errorf("层 %s 不存在", id)
+	}
+	return dir, nil
+}
+
+func (d *vfsDriver) Unmount(id string) error {
+	return validLayerId(id)
+}
+
+// Diff vfs的每层都是完整的文件系统，没有单独保存修改的目录
+func (d *vfsDriver) Diff(id string) (string, error) {
+	return d.Mount(id)
+}
+
+func (d *vfsDriver) Remove(id string) error {
+	if err := validLayerId(id); err != nil {
+		return err
+	}
+
+	// 容器挂载在根目录下的proc等文件系统可能还没有卸载
+	dir := d.dir(id)
+	if mounted, err := IsMountPoint(dir); err == nil && mounted {
+		unix.Unmount(dir, unix.MNT_DETACH)
+	}
+	return os.RemoveAll(dir)
+}
+
+func (d *vfsDriver) dir(id string) string {
+	return filepath.Join(d.root, "dir", id)
+}
+
+// CopyDir 复制目录树，保留权限、属主、符号链接和设备文件
+func CopyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		stat := info.Sys().(*syscall.Stat_t)
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if err := os.Mkdir(target, mode.Perm()); err != nil && !os.IsExist(err) {
+				return err
+			}
+		case mode.IsRegular():
+			if err := copyFile(path, target, mode.Perm()); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		default:
+			// 设备文件、管道和socket
+			if err := unix.Mknod(target, stat.Mode, int(stat.Rdev)); err != nil {
+				return err
+			}
+		}
+
+		if err := os.Lchown(target, int(stat.Uid), int(stat.Gid)); err != nil {
+			return err
+		}
+		// chown会清除setuid等特殊权限位，最后再设置一次权限
+		if info.Mode()&os.ModeSymlink == 0 {
+			return unix.Chmod(target, stat.Mode&07777)
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
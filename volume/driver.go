@@ -0,0 +1,200 @@
+package volume
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/akm/godocker/resources"
+	"github.com/akm/godocker/storage"
+	"golang.org/x/sys/unix"
+)
+
+// local驱动支持的数据卷类型，通过 --opt type=... 指定
+const (
+	// 默认类型，数据直接保存在主机目录中，不限制大小
+	TypeDirectory = ""
+	// 内存文件系统，主机重启或删除数据卷后数据丢失
+	TypeTmpfs = "tmpfs"
+	// 稀疏文件格式化为ext4后通过loop设备挂载，大小固定
+	TypeExt4Image = "ext4-image"
+
+	imageFileName = "disk.img"
+)
+
+// validateOptions 检查驱动选项是否合法
+func validateOptions(driver string, options map[string]string) error {
+	if driver != LocalDriver {
+		return fmt.Errorf("不支持的数据卷驱动: %s", driver)
+	}
+
+	for key, value := range options {
+		switch key {
+		case "type":
+			if value != TypeDirectory && value != TypeTmpfs && value != TypeExt4Image {
+				return fmt.Errorf("不支持的数据卷类型: %s", value)
+			}
+		case "size":
+			if _, err := resources.ParseSize(value); err != nil {
+				return err
+			}
+		case "o":
+			if options["type"] != TypeTmpfs {
+				return fmt.Errorf("只有tmpfs类型的数据卷支持 o 选项")
+			}
+			if _, err := parseTmpfsOptions(value); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("不支持的数据卷选项: %s", key)
+		}
+	}
+
+	switch options["type"] {
+	case TypeDirectory:
+		if options["size"] != "" {
+			return fmt.Errorf("目录类型的数据卷不支持限制大小，请指定 type=tmpfs 或 type=ext4-image")
+		}
+	case TypeExt4Image:
+		if options["size"] == "" {
+			return fmt.Errorf("ext4-image 类型的数据卷必须指定 size")
+		}
+	}
+	return nil
+}
+
+// setupVolume 创建数据卷时准备存储，ext4-image 类型需要创建并格式化镜像文件
+func setupVolume(vol *Volume) error {
+	if vol.Options["type"] != TypeExt4Image {
+		return nil
+	}
+
+	size, err := resources.ParseSize(vol.Options["size"])
+	if err != nil {
+		return err
+	}
+
+	// 创建稀疏文件，只有写入的数据才会占用磁盘空间
+	image := vol.imagePath()
+	file, err := os.OpenFile(image, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("创建镜像文件失败: %v", err)
+	}
+	err = file.Truncate(size)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("创建镜像文件失败: %v", err)
+	}
+
+	if output, err := exec.Command("mkfs.ext4", "-q", "-F", image).CombinedOutput(); err != nil {
+		return fmt.Errorf("格式化镜像文件失败: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// parseTmpfsOptions 解析tmpfs数据卷的 o 选项，如 "uid=1000,gid=1000,mode=770"
+func parseTmpfsOptions(o string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, item := range strings.Split(o, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("无效的tmpfs选项: %s", item)
+		}
+		key, value := parts[0], parts[1]
+		switch key {
+		case "uid", "gid":
+			if id, err := strconv.Atoi(value); err != nil || id < 0 {
+				return nil, fmt.Errorf("无效的tmpfs选项 %s: %s", key, value)
+			}
+		case "mode":
+			if mode, err := strconv.ParseUint(value, 8, 32); err != nil || mode > 07777 {
+				return nil, fmt.Errorf("无效的tmpfs选项 mode: %s", value)
+			}
+		default:
+			return nil, fmt.Errorf("不支持的tmpfs选项: %s", key)
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// Owner 返回数据卷根目录的属主，tmpfs数据卷可以通过 o=uid=...,gid=... 指定，默认为root
+// 这是容器内的ID，容器使用user namespace时由调用方转换为主机上的ID
+func (vol *Volume) Owner() (uid, gid int) {
+	options, _ := parseTmpfsOptions(vol.Options["o"])
+	uid, _ = strconv.Atoi(options["uid"])
+	gid, _ = strconv.Atoi(options["gid"])
+	return uid, gid
+}
+
+// Mount 按数据卷类型挂载数据目录，已经挂载时不做任何操作
+// tmpfs的根目录属于主机上的uid和gid；主机重启后tmpfs和loop设备的挂载会丢失，容器启动前需要调用
+func Mount(vol *Volume, uid, gid int) error {
+	if vol.Options["type"] == TypeDirectory {
+		return nil
+	}
+
+	mounted, err := storage.IsMountPoint(vol.Mountpoint)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		return nil
+	}
+
+	switch vol.Options["type"] {
+	case TypeTmpfs:
+		mode := "755"
+		if options, _ := parseTmpfsOptions(vol.Options["o"]); options["mode"] != "" {
+			mode = options["mode"]
+		}
+		data := fmt.Sprintf("mode=%s,uid=%d,gid=%d", mode, uid, gid)
+		if size := vol.Options["size"]; size != "" {
+			data += ",size=" + size
+		}
+		if err := unix.Mount("tmpfs", vol.Mountpoint, "tmpfs", 0, data); err != nil {
+			return fmt.Errorf("挂载数据卷 %s 失败: %v", vol.Name, err)
+		}
+	case TypeExt4Image:
+		if err := mountLoop(vol.imagePath(), vol.Mountpoint, "ext4"); err != nil {
+			return fmt.Errorf("挂载数据卷 %s 失败: %v", vol.Name, err)
+		}
+	}
+	return nil
+}
+
+// unmountVolume 卸载数据目录并释放数据卷使用的loop设备
+func unmountVolume(vol *Volume) error {
+	if vol.Options["type"] == TypeDirectory {
+		return nil
+	}
+
+	mounted, err := storage.IsMountPoint(vol.Mountpoint)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		if err := unix.Unmount(vol.Mountpoint, 0); err != nil {
+			if err != unix.EBUSY {
+				return fmt.Errorf("卸载数据卷 %s 失败: %v", vol.Name, err)
+			}
+			// 仍有进程在使用时延迟卸载，loop设备在最后一个引用释放后自动清除
+			if err := unix.Unmount(vol.Mountpoint, unix.MNT_DETACH); err != nil {
+				return fmt.Errorf("卸载数据卷 %s 失败: %v", vol.Name, err)
+			}
+		}
+	}
+
+	if vol.Options["type"] == TypeExt4Image {
+		return detachLoops(vol.imagePath())
+	}
+	return nil
+}
+
+// imagePath ext4-image 类型数据卷的镜像文件路径
+func (vol *Volume) imagePath() string {
+	return filepath.Join(DefaultVolumeRoot, vol.Name, imageFileName)
+}
@@ -0,0 +1,121 @@
+package volume
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const loopControlPath = "/dev/loop-control"
+
+// mountLoop 把镜像文件关联到空闲的loop设备并挂载到target
+// loop设备设置了自动清除标志，文件系统卸载后内核会自动解除关联
+func mountLoop(image, target, fstype string) error {
+	backing, err := os.OpenFile(image, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("打开镜像文件失败: %v", err)
+	}
+	defer backing.Close()
+
+	control, err := os.OpenFile(loopControlPath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("打开 %s 失败: %v", loopControlPath, err)
+	}
+	defer control.Close()
+
+	// 其他进程可能同时申请到同一个设备，关联失败时重新申请
+	for retry := 0; retry < 10; retry++ {
+		index, err := unix.IoctlRetInt(int(control.Fd()), unix.LOOP_CTL_GET_FREE)
+		if err != nil {
+			return fmt.Errorf("申请loop设备失败: %v", err)
+		}
+
+		loop, err := openLoopDevice(index)
+		if err != nil {
+			return err
+		}
+
+		if err := unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_SET_FD, int(backing.Fd())); err != nil {
+			loop.Close()
+			if err == unix.EBUSY {
+				continue
+			}
+			return fmt.Errorf("关联loop设备失败: %v", err)
+		}
+
+		// 必须在挂载完成之后才关闭loop设备，否则自动清除标志会让设备立即被释放
+		err = setupLoopAndMount(loop, image, target, fstype)
+		loop.Close()
+		return err
+	}
+	return fmt.Errorf("申请loop设备失败: 没有空闲的设备")
+}
+
+// setupLoopAndMount 设置loop设备的自动清除标志并挂载，失败时解除关联
+func setupLoopAndMount(loop *os.File, image, target, fstype string) error {
+	info := unix.LoopInfo64{Flags: unix.LO_FLAGS_AUTOCLEAR}
+	copy(info.File_name[:], image)
+	if err := unix.IoctlLoopSetStatus64(int(loop.Fd()), &info); err != nil {
+		unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_CLR_FD, 0)
+		return fmt.Errorf("设置loop设备失败: %v", err)
+	}
+
+	if err := unix.Mount(loop.Name(), target, fstype, 0, ""); err != nil {
+		unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_CLR_FD, 0)
+		return err
+	}
+	return nil
+}
+
+// openLoopDevice 打开 /dev/loopN，设备文件不存在时创建
+func openLoopDevice(index int) (*os.File, error) {
+	path := fmt.Sprintf("/dev/loop%d", index)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// loop设备的主设备号为7
+		if err := unix.Mknod(path, unix.S_IFBLK|0660, int(unix.Mkdev(7, uint32(index)))); err != nil {
+			return nil, fmt.Errorf("创建设备文件 %s 失败: %v", path, err)
+		}
+	}
+
+	loop, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("打开loop设备失败: %v", err)
+	}
+	return loop, nil
+}
+
+// detachLoops 解除所有仍关联着镜像文件的loop设备
+// 正常情况下卸载后设备会自动清除，这里处理进程异常退出后残留的设备
+func detachLoops(image string) error {
+	files, err := filepath.Glob("/sys/block/loop*/loop/backing_file")
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		backing := strings.TrimSuffix(strings.TrimSpace(string(data)), " (deleted)")
+		if backing != image {
+			continue
+		}
+
+		// /sys/block/loopN/loop/backing_file -> /dev/loopN
+		device := filepath.Join("/dev", filepath.Base(filepath.Dir(filepath.Dir(file))))
+		loop, err := os.OpenFile(device, os.O_RDWR, 0)
+		if err != nil {
+			return fmt.Errorf("打开loop设备失败: %v", err)
+		}
+		err = unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_CLR_FD, 0)
+		loop.Close()
+		if err != nil && err != unix.ENXIO {
+			return fmt.Errorf("释放loop设备 %s 失败: %v", device, err)
+		}
+	}
+	return nil
+}
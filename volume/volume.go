@@ -0,0 +1,247 @@
+package volume
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/akm/godocker/config"
+	"github.com/akm/godocker/storage"
+)
+
+// Volume 命名数据卷
+type Volume struct {
+	Name       string            // 数据卷名称
+	Driver     string            // 数据卷驱动
+	Options    map[string]string // 驱动选项，如 type=tmpfs、size=64m
+	Mountpoint string            // 数据在主机上的目录
+	Labels     map[string]string // 用户自定义标签
+	CreatedAt  time.Time         // 创建时间
+}
+
+// 数据卷存储根目录，每个数据卷一个子目录，数据保存在其中的 _data 目录
+var DefaultVolumeRoot = filepath.Join(config.DataRoot(), "volumes")
+
+const (
+	// 默认的数据卷驱动，数据直接保存在主机目录中
+	LocalDriver = "local"
+
+	metadataFileName = "volume.json"
+	dataDirName      = "_data"
+)
+
+// 数据卷相关的错误，调用方可以使用 errors.Is 判断
+var (
+	ErrVolumeNotFound = errors.New("找不到数据卷")
+	ErrVolumeExists   = errors.New("数据卷已存在")
+	ErrVolumeInUse    = errors.New("数据卷正在被容器使用")
+)
+
+// 数据卷名称只能包含字母、数字和 _.-，且以字母或数字开头
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidName 判断是否为合法的数据卷名称
+func ValidName(name string) bool {
+	return volumeNamePattern.MatchString(name)
+}
+
+// Create 创建命名数据卷，name为空时生成随机名称
+func Create(name, driver string, options, labels map[string]string) (*Volume, error) {
+	if name == "" {
+		name = generateVolumeName()
+	}
+	if !ValidName(name) {
+		return nil, fmt.Errorf("无效的数据卷名称: %s", name)
+	}
+	if driver == "" {
+		driver = LocalDriver
+	}
+	if err := validateOptions(driver, options); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(DefaultVolumeRoot, name)
+	// 目录对其他用户只开放执行权限，user namespace中的容器才能访问到数据目录
+	if err := os.MkdirAll(DefaultVolumeRoot, 0711); err != nil {
+		return nil, fmt.Errorf("创建数据卷目录失败: %v", err)
+	}
+	if err := os.Mkdir(dir, 0711); err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrVolumeExists, name)
+		}
+		return nil, fmt.Errorf("创建数据卷目录失败: %v", err)
+	}
+
+	vol := &Volume{
+		Name:       name,
+		Driver:     driver,
+		Options:    options,
+		Mountpoint: filepath.Join(dir, dataDirName),
+		Labels:     labels,
+		CreatedAt:  time.Now(),
+	}
+	if vol.Options == nil {
+		vol.Options = map[string]string{}
+	}
+	if vol.Labels == nil {
+		vol.Labels = map[string]string{}
+	}
+
+	if err := os.Mkdir(vol.Mountpoint, 0755); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("创建数据卷目录失败: %v", err)
+	}
+	if err := setupVolume(vol); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	uid, gid := vol.Owner()
+	if err := Mount(vol, uid, gid); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := saveVolume(vol); err != nil {
+		unmountVolume(vol)
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return vol, nil
+}
+
+// Get 按名称获取数据卷
+func Get(name string) (*Volume, error) {
+	if !ValidName(name) {
+		return nil, fmt.Errorf("%w: %s", ErrVolumeNotFound, name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(DefaultVolumeRoot, name, metadataFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrVolumeNotFound, name)
+		}
+		return nil, fmt.Errorf("读取数据卷信息失败: %v", err)
+	}
+
+	var vol Volume
+	if err := json.Unmarshal(data, &vol); err != nil {
+		return nil, fmt.Errorf("解析数据卷信息失败: %v", err)
+	}
+	return &vol, nil
+}
+
+// GetOrCreate 获取数据卷，不存在时自动创建
+func GetOrCreate(name string) (*Volume, error) {
+	vol, err := Get(name)
+	if errors.Is(err, ErrVolumeNotFound) {
+		vol, err = Create(name, LocalDriver, nil, nil)
+		if errors.Is(err, ErrVolumeExists) {
+			// 其他godocker进程同时创建了同名数据卷
+			return Get(name)
+		}
+	}
+	return vol, err
+}
+
+// List 列出所有数据卷，按名称排序
+func List() ([]*Volume, error) {
+	entries, err := os.ReadDir(DefaultVolumeRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("读取数据卷目录失败: %v", err)
+	}
+
+	var volumes []*Volume
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		vol, err := Get(entry.Name())
+		if err != nil {
+			// 跳过正在创建或已损坏的数据卷
+			continue
+		}
+		volumes = append(volumes, vol)
+	}
+
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Name < volumes[j].Name
+	})
+	return volumes, nil
+}
+
+// Remove 卸载并删除数据卷及其中的数据
+// 调用方负责确认数据卷没有被容器使用
+func Remove(name string) error {
+	vol, err := Get(name)
+	if err != nil {
+		return err
+	}
+	if err := unmountVolume(vol); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(DefaultVolumeRoot, name)); err != nil {
+		return fmt.Errorf("删除数据卷失败: %v", err)
+	}
+	return nil
+}
+
+// Populate 数据卷为空时，把镜像中对应路径的内容复制到数据卷中
+// src 为容器根文件系统中挂载点对应的目录，不存在或不是目录时不做任何操作
+func Populate(vol *Volume, src string) error {
+	info, err := os.Stat(src)
+	if err != nil || !info.IsDir() {
+		return nil
+	}
+
+	entries, err := os.ReadDir(vol.Mountpoint)
+	if err != nil {
+		return fmt.Errorf("读取数据卷失败: %v", err)
+	}
+	for _, entry := range entries {
+		// 新格式化的ext4文件系统中只有 lost+found
+		if entry.Name() != "lost+found" {
+			return nil
+		}
+	}
+
+	if err := storage.CopyDir(src, vol.Mountpoint); err != nil {
+		return fmt.Errorf("复制镜像内容到数据卷 %s 失败: %v", vol.Name, err)
+	}
+	return nil
+}
+
+// saveVolume 保存数据卷信息，先写临时文件再重命名，避免读到不完整的内容
+func saveVolume(vol *Volume) error {
+	data, err := json.MarshalIndent(vol, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化数据卷信息失败: %v", err)
+	}
+
+	path := filepath.Join(DefaultVolumeRoot, vol.Name, metadataFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("保存数据卷信息失败: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("保存数据卷信息失败: %v", err)
+	}
+	return nil
+}
+
+// 生成随机的数据卷名称
+func generateVolumeName() string {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}